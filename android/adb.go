@@ -19,7 +19,11 @@
 package android
 
 import (
+	"errors"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type AdbError struct {
@@ -35,12 +39,30 @@ func NewAdbError(output string, err error) *AdbError {
 	return &AdbError{output, err}
 }
 
+// ErrRootDenied is returned by Root on production builds, where adbd refuses
+// to run as root.
+var ErrRootDenied = errors.New("adbd cannot run as root on this build")
+
 type AdbClient struct {
 	BinaryAndroidTool
+
+	// Don't ask adb for progress it redraws in place, for when output isn't
+	// going to a terminal
+	NoProgress bool
 }
 
 func NewAdbClient() AdbClient {
-	return AdbClient{BinaryAndroidTool{"adb"}}
+	return AdbClient{BinaryAndroidTool: BinaryAndroidTool{Name: "adb"}}
+}
+
+// Version returns the platform-tools version of adb, or "" if it is too old
+// to report one.
+func (a *AdbClient) Version() (version string, err error) {
+	output, err := a.Run("version")
+	if err != nil {
+		return "", NewAdbError(output, err)
+	}
+	return toolsVersion(output), nil
 }
 
 func (a *AdbClient) Status() (AndroidDeviceStatus, error) {
@@ -59,15 +81,76 @@ func (a *AdbClient) Status() (AndroidDeviceStatus, error) {
 		return NoUsbPerms, nil
 	} else if strings.Contains(output, "unauthorized") {
 		return DeviceUnauthorized, nil
+	} else if strings.Contains(output, "\toffline") {
+		return DeviceOffline, nil
 	} else {
 		return DeviceConnected, nil
 	}
 }
 
+// KillServer stops the adb server, which can get stuck reporting a device as
+// offline or missing until it is restarted.
+func (a *AdbClient) KillServer() error {
+	output, err := a.Run("kill-server")
+	if err != nil {
+		return NewAdbError(output, err)
+	}
+	return nil
+}
+
+// StartServer starts the adb server if it isn't running.
+func (a *AdbClient) StartServer() error {
+	output, err := a.Run("start-server")
+	if err != nil {
+		return NewAdbError(output, err)
+	}
+	return nil
+}
+
+// InRecovery reports whether the device is booted into recovery.
+func (a *AdbClient) InRecovery() bool {
+	output, err := a.Run("get-state")
+	return err == nil && strings.TrimSpace(output) == "recovery"
+}
+
+// WaitForRecovery waits for the device to show up in adb booted into
+// recovery.
+func (a *AdbClient) WaitForRecovery(timeout time.Duration) error {
+	status := func() (AndroidDeviceStatus, error) {
+		if a.InRecovery() {
+			return DeviceConnected, nil
+		}
+		return NoDeviceFound, nil
+	}
+	return WaitUntil(a.context(), status, DevicePresent, timeout)
+}
+
+// Push copies local to remote on the device without printing anything.
+func (a *AdbClient) Push(local, remote string) (err error) {
+	output, err := a.Run("push", local, remote)
+	if err != nil {
+		return NewAdbError(output, err)
+	}
+	return nil
+}
+
+// FileSize returns the size in bytes of path on the device.
+func (a *AdbClient) FileSize(path string) (int64, error) {
+	output, err := a.ShellOutput("stat -c %s " + ShellQuote(path))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(output), 10, 64)
+}
+
 func (a *AdbClient) PushFg(local, remote string) (err error) {
-	err = a.RunFg("push", "-p", local, remote)
+	args := []string{"push", "-p", local, remote}
+	if a.NoProgress {
+		args = []string{"push", local, remote}
+	}
+	output, err := a.RunStream(os.Stdout, args...)
 	if err != nil {
-		return NewAdbError("", err)
+		return NewAdbError(output, err)
 	}
 	return err
 }
@@ -95,3 +178,90 @@ func (a *AdbClient) Shell(cmd string) (err error) {
 	}
 	return err
 }
+
+// ShellQuote quotes s as a single argument for the device's shell, since adb
+// shell runs its arguments as one command line.
+func ShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// GetProp returns the value of the system property name.
+func (a *AdbClient) GetProp(name string) (string, error) {
+	output, err := a.ShellOutput("getprop " + name)
+	return strings.TrimSpace(output), err
+}
+
+// Charging reports whether the device is plugged into power. Android is asked
+// with dumpsys, while recoveries without it like TWRP are read from sysfs.
+func (a *AdbClient) Charging() (bool, error) {
+	output, err := a.ShellOutput("dumpsys battery")
+	if err == nil && strings.Contains(output, "powered:") {
+		for _, line := range strings.Split(output, "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasSuffix(line, "powered: true") {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	output, err = a.ShellOutput("cat /sys/class/power_supply/battery/status")
+	if err != nil {
+		return false, err
+	}
+	switch strings.TrimSpace(output) {
+	case "Charging", "Full":
+		return true, nil
+	case "Discharging", "Not charging":
+		return false, nil
+	}
+	return false, errors.New("unknown battery status: " + strings.TrimSpace(output))
+}
+
+// ShellOutput runs cmd on the device and returns its output.
+func (a *AdbClient) ShellOutput(cmd string) (output string, err error) {
+	output, err = a.Run("shell", cmd)
+	if err != nil {
+		return output, NewAdbError(output, err)
+	}
+	return output, err
+}
+
+// Root restarts adbd as root and waits for it to come back. Only userdebug and
+// eng builds allow this.
+func (a *AdbClient) Root() (err error) {
+	output, err := a.Run("root")
+	if strings.Contains(output, "production builds") {
+		return NewAdbError(output, ErrRootDenied)
+	}
+	if err != nil {
+		return NewAdbError(output, err)
+	}
+	if strings.Contains(output, "already running as root") {
+		return nil
+	}
+
+	output, err = a.Run("wait-for-device")
+	if err != nil {
+		return NewAdbError(output, err)
+	}
+	return err
+}
+
+// Remount remounts the system partitions read-write. adbd must already be
+// running as root, see Root.
+func (a *AdbClient) Remount() (err error) {
+	output, err := a.Run("remount")
+	if err == nil && !strings.Contains(strings.ToLower(output), "remount succeeded") {
+		err = errors.New("remount failed: " + strings.TrimSpace(output))
+	}
+	if err != nil {
+		return NewAdbError(output, err)
+	}
+
+	output, err = a.Run("wait-for-device")
+	if err != nil {
+		return NewAdbError(output, err)
+	}
+	return err
+}
@@ -0,0 +1,36 @@
+package android
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain.zip", `'plain.zip'`},
+		{"my update.zip", `'my update.zip'`},
+		{"it's.zip", `'it'\''s.zip'`},
+		{"$HOME.zip", `'$HOME.zip'`},
+		{"a;reboot.zip", `'a;reboot.zip'`},
+		{"`reboot`.zip", "'`reboot`.zip'"},
+		{"", `''`},
+	}
+	for _, tt := range tests {
+		got := ShellQuote(tt.in)
+		if got != tt.want {
+			t.Errorf("ShellQuote(%q) = %s, want %s", tt.in, got, tt.want)
+			continue
+		}
+
+		// the shell must give back exactly one argument, unchanged
+		out, err := exec.Command("sh", "-c", `args() { printf '%s|%s' "$#" "$1"; }; args `+got).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "1|" + tt.in; string(out) != want {
+			t.Errorf("sh parsed %s as %q, want %q", got, out, want)
+		}
+	}
+}
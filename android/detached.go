@@ -0,0 +1,107 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package android
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrJobLost is returned by DetachedJob.Poll when the job's files are gone,
+// which means the device restarted before it finished.
+var ErrJobLost = errors.New("the device restarted before the command finished")
+
+// DetachedJob is a shell command left running in the background on the
+// device, so it outlives the adb connection that started it. Its output and
+// exit status are written to files under /tmp, which can still be read once
+// adb is back after a lost connection.
+type DetachedJob struct {
+	adb    *AdbClient
+	log    string
+	status string
+	// lines of the log already passed on by Poll
+	lines  int
+	output strings.Builder
+}
+
+// StartDetached starts cmd as a DetachedJob. name tells apart the files of
+// jobs run at the same time.
+func (a *AdbClient) StartDetached(name, cmd string) (*DetachedJob, error) {
+	j := &DetachedJob{
+		adb:    a,
+		log:    "/tmp/nh-" + name + ".log",
+		status: "/tmp/nh-" + name + ".status",
+	}
+	if err := a.Shell(detachedCmd(cmd, j.log, j.status)); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// detachedCmd returns the command line that runs cmd in the background,
+// ignoring the hangup sent when adb drops out, with its output going to log
+// and its exit status to status. log is created up front so a missing log
+// always means the device restarted.
+func detachedCmd(cmd, log, status string) string {
+	script := "(" + cmd + ") >> " + ShellQuote(log) + " 2>&1; echo $? > " + ShellQuote(status)
+	return "rm -f " + ShellQuote(status) + "; : > " + ShellQuote(log) + "; " +
+		"trap '' HUP; sh -c " + ShellQuote(script) + " < /dev/null > /dev/null 2>&1 &"
+}
+
+// Poll writes the lines the job printed since the last call to w and
+// reports whether it has finished, with its exit status if so.
+func (j *DetachedJob) Poll(w io.Writer) (done bool, status int, err error) {
+	// read the status first so no output is missed once it's done
+	state, err := j.adb.ShellOutput("cat " + ShellQuote(j.status) + " 2>/dev/null || test -e " + ShellQuote(j.log) + " || echo lost")
+	if err != nil {
+		return false, 0, err
+	}
+	state = strings.TrimSpace(state)
+	if state == "lost" {
+		return false, 0, ErrJobLost
+	}
+	done = state != ""
+
+	output, err := j.adb.ShellOutput("tail -n +" + strconv.Itoa(j.lines+1) + " " + ShellQuote(j.log))
+	if err != nil {
+		return false, 0, err
+	}
+	output = strings.Replace(output, "\r\n", "\n", -1)
+	if !done {
+		// a partial line is passed on with the rest of it next time
+		output = output[:strings.LastIndex(output, "\n")+1]
+	}
+	j.lines += strings.Count(output, "\n")
+	j.output.WriteString(output)
+	io.WriteString(w, output)
+
+	if !done {
+		return false, 0, nil
+	}
+	status, err = strconv.Atoi(state)
+	if err != nil {
+		return false, 0, errors.New("unexpected exit status " + strconv.Quote(state))
+	}
+	return true, status, nil
+}
+
+// Output returns everything the job printed so far.
+func (j *DetachedJob) Output() string {
+	return j.output.String()
+}
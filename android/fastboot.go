@@ -22,7 +22,17 @@
 package android
 
 import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type FastbootError struct {
@@ -40,23 +50,84 @@ func NewFastbootError(output string, err error) *FastbootError {
 
 type FastbootClient struct {
 	BinaryAndroidTool
+
+	// Slot images are flashed to on A/B devices: "a", "b", "other", or ""
+	// for the active one
+	Slot string
 }
 
 func NewFastbootClient() FastbootClient {
-	return FastbootClient{BinaryAndroidTool{"fastboot"}}
+	return FastbootClient{BinaryAndroidTool: BinaryAndroidTool{Name: "fastboot"}}
+}
+
+// DeviceWait is how long fastboot commands wait for a device to show up.
+// fastboot itself waits forever, which looks like the installer has frozen.
+var DeviceWait = 30 * time.Second
+
+// quickTimeout bounds commands that should return straight away, in case the
+// device goes away after it was seen.
+const quickTimeout = time.Minute
+
+// waitForDevice waits up to DeviceWait for fastboot to see a device.
+func (f *FastbootClient) waitForDevice() error {
+	err := f.WaitUntil(DevicePresent, DeviceWait)
+	if err == ErrWaitTimeout {
+		err = fmt.Errorf("no fastboot device within %v", DeviceWait)
+	}
+	return err
+}
+
+// runOnDevice runs a fastboot command that needs a device, once there is one.
+func (f *FastbootClient) runOnDevice(args ...string) (string, error) {
+	if err := f.waitForDevice(); err != nil {
+		return "", err
+	}
+	return f.Run(args...)
+}
+
+// runQuick is runOnDevice for commands that shouldn't take long.
+func (f *FastbootClient) runQuick(args ...string) (string, error) {
+	if err := f.waitForDevice(); err != nil {
+		return "", err
+	}
+	output, err := f.RunTimeout(quickTimeout, args...)
+	if err == context.DeadlineExceeded {
+		err = fmt.Errorf("fastboot %s didn't respond within %v", strings.Join(args, " "), quickTimeout)
+	}
+	return output, err
+}
+
+// GetVar returns the value of a bootloader variable, or "" if the bootloader
+// doesn't know it.
+func (f *FastbootClient) GetVar(variable string) (value string, err error) {
+	return f.getVar(variable)
 }
 
 func (f *FastbootClient) getVar(variable string) (value string, err error) {
-	output, err := f.Run("getvar", variable)
+	output, err := f.runQuick("getvar", variable)
 	if err != nil {
 		return output, NewFastbootError(output, err)
 	}
 
-	// fastboot reports "[variable]: [value]\n...\n"
-	value = strings.Split(strings.Split(output, FastbootLineSeperator)[0], " ")[1]
+	// fastboot reports "[variable]: [value]\n...\n" and leaves [value] empty
+	// for variables the bootloader doesn't know about
+	line := strings.Split(output, FastbootLineSeperator)[0]
+	if i := strings.Index(line, ":"); i >= 0 {
+		value = strings.TrimSpace(line[i+1:])
+	}
 	return value, nil
 }
 
+// Version returns the platform-tools version of fastboot, or "" if it is too
+// old to report one.
+func (f *FastbootClient) Version() (version string, err error) {
+	output, err := f.Run("--version")
+	if err != nil {
+		return "", NewFastbootError(output, err)
+	}
+	return toolsVersion(output), nil
+}
+
 func (f *FastbootClient) Status() (AndroidDeviceStatus, error) {
 	output, err := f.Run("devices")
 	if err != nil {
@@ -76,16 +147,242 @@ func (f *FastbootClient) GetProduct() (product string, err error) {
 	return f.getVar("product")
 }
 
-func (f *FastbootClient) FlashRecovery(image string) (err error) {
-	output, err := f.Run("flash", "recovery", image)
+// GetBootloaderVersion returns the bootloader version in the vendor's own
+// format.
+func (f *FastbootClient) GetBootloaderVersion() (version string, err error) {
+	return f.getVar("version-bootloader")
+}
+
+// DeviceIdentity combines the fastboot variables that can be used to tell
+// devices apart. Several vendors (OnePlus for example) report the same
+// product for different devices, so the variant and serial number are
+// collected as well.
+type DeviceIdentity struct {
+	Product  string
+	Variant  string
+	Serialno string
+}
+
+// GetIdentity reads the product, variant and serialno variables. Only the
+// product is required; the others are left empty if the bootloader doesn't
+// report them.
+func (f *FastbootClient) GetIdentity() (id DeviceIdentity, err error) {
+	id.Product, err = f.GetProduct()
+	if err != nil {
+		return id, err
+	}
+	id.Variant, _ = f.getVar("variant")
+	id.Serialno, _ = f.getVar("serialno")
+	return id, nil
+}
+
+// HasSlots reports whether the device has A/B slots.
+func (f *FastbootClient) HasSlots() (bool, error) {
+	value, err := f.getVar("slot-count")
+	if err != nil || value == "" {
+		return false, err
+	}
+	count, err := strconv.Atoi(value)
+	return count > 1, err
+}
+
+// MaxDownloadSize returns the largest image in bytes the bootloader can
+// receive in one go, or 0 if it doesn't say.
+func (f *FastbootClient) MaxDownloadSize() (int64, error) {
+	value, err := f.getVar("max-download-size")
+	if err != nil || value == "" {
+		return 0, err
+	}
+	// reported in hex by most bootloaders, e.g. "0x20000000"
+	return strconv.ParseInt(value, 0, 64)
+}
+
+// Flash writes image to partition, in Slot if one is set. Images larger
+// than the bootloader's max-download-size are sent as sparse chunks that
+// fit.
+func (f *FastbootClient) Flash(partition, image string) (err error) {
+	if f.Slot != "" {
+		return f.flash([]string{"--slot=" + f.Slot}, partition, image)
+	}
+	return f.flash(nil, partition, image)
+}
+
+// FlashAllSlots is Flash for A/B devices that writes image to partition in
+// both slots, or only in Slot if one is set.
+func (f *FastbootClient) FlashAllSlots(partition, image string) (err error) {
+	if f.Slot != "" {
+		return f.Flash(partition, image)
+	}
+	return f.flash([]string{"--slot=all"}, partition, image)
+}
+
+// SetActive makes slot the one the device boots from.
+func (f *FastbootClient) SetActive(slot string) error {
+	output, err := f.runOnDevice("--set-active=" + slot)
 	if err != nil {
 		return NewFastbootError(output, err)
 	}
 	return nil
 }
 
+func (f *FastbootClient) flash(opts []string, partition, image string) (err error) {
+	args := append(opts, "flash", partition, image)
+
+	max, _ := f.MaxDownloadSize()
+	split := false
+	if info, err := os.Stat(image); err == nil && max > 0 && info.Size() > max {
+		args = append([]string{"-S", strconv.FormatInt(max, 10)}, args...)
+		split = true
+	}
+
+	output, err := f.runOnDevice(args...)
+	if err != nil {
+		if split && sparseFailed(output) {
+			err = fmt.Errorf("%v (%s is larger than the %d byte download buffer and couldn't be split into sparse chunks)", err, image, max)
+		}
+		return NewFastbootError(output, err)
+	}
+	return nil
+}
+
+// sparseMessages are what fastboot and bootloaders print when an image
+// couldn't be split into sparse chunks or a chunk didn't fit the download
+// buffer.
+var sparseMessages = []string{"sparse", "too large", "download size", "max allowed"}
+
+// sparseFailed reports whether the error lines of a failed flash blame
+// splitting the image, rather than e.g. a lost connection or a locked
+// bootloader. Progress lines mention sparse chunks either way.
+func sparseFailed(output string) bool {
+	for _, line := range strings.Split(strings.ToLower(output), "\n") {
+		if !strings.Contains(line, "failed") && !strings.Contains(line, "error") {
+			continue
+		}
+		for _, m := range sparseMessages {
+			if strings.Contains(line, m) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ErrReadbackUnsupported is returned by VerifyPartition when the device or
+// fastboot can't read partitions back.
+var ErrReadbackUnsupported = errors.New("reading partitions back isn't supported")
+
+// VerifyPartition reads partition back from the device with "fastboot fetch"
+// and makes sure it starts with the contents of image, since partitions are
+// usually larger than what was flashed to them.
+func (f *FastbootClient) VerifyPartition(partition, image string) error {
+	tmp, err := ioutil.TempFile("", partition)
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	// old fastboot doesn't know fetch and most bootloaders refuse it, with
+	// all sorts of messages
+	if _, err := f.runOnDevice("fetch", partition, tmp.Name()); err != nil {
+		return ErrReadbackUnsupported
+	}
+
+	want, size, err := md5File(image, -1)
+	if err != nil {
+		return err
+	}
+	got, n, err := md5File(tmp.Name(), size)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrReadbackUnsupported
+	}
+	if n < size || got != want {
+		return fmt.Errorf("%s doesn't match %s after flashing (md5 %s, expected %s)", partition, image, got, want)
+	}
+	return nil
+}
+
+// md5File returns the hex encoded md5 sum of the first limit bytes of file,
+// or all of it if limit is negative, along with how many bytes were hashed.
+func md5File(file string, limit int64) (string, int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if limit >= 0 {
+		r = io.LimitReader(f, limit)
+	}
+	h := md5.New()
+	n, err := io.Copy(h, r)
+	return hex.EncodeToString(h.Sum(nil)), n, err
+}
+
+func (f *FastbootClient) FlashRecovery(image string) (err error) {
+	return f.Flash("recovery", image)
+}
+
 func (f *FastbootClient) Boot(image string) (err error) {
-	output, err := f.Run("boot", image)
+	output, err := f.runOnDevice("boot", image)
+	if err != nil {
+		return NewFastbootError(output, err)
+	}
+	return nil
+}
+
+// BootAndWait boots image, which must be a recovery, and waits for adb to
+// see the device booted into it. If it doesn't show up in time while the
+// device is still in the bootloader, the image is booted once more.
+func (f *FastbootClient) BootAndWait(image string, adb *AdbClient, timeout time.Duration) error {
+	if err := f.Boot(image); err != nil {
+		return err
+	}
+	err := adb.WaitForRecovery(timeout)
+	if err != ErrWaitTimeout {
+		return err
+	}
+	if status, serr := f.Status(); serr != nil || status != DeviceConnected {
+		return err
+	}
+	if err := f.Boot(image); err != nil {
+		return err
+	}
+	return adb.WaitForRecovery(timeout)
+}
+
+// IsUserspace reports whether the device is in fastbootd, the userspace
+// fastboot used to flash dynamic partitions, rather than the bootloader.
+func (f *FastbootClient) IsUserspace() (bool, error) {
+	value, err := f.getVar("is-userspace")
+	return "yes" == value, err
+}
+
+// RebootFastbootd reboots from the bootloader into fastbootd.
+func (f *FastbootClient) RebootFastbootd() (err error) {
+	output, err := f.runQuick("reboot", "fastboot")
+	if err != nil {
+		return NewFastbootError(output, err)
+	}
+	return nil
+}
+
+// RebootBootloader reboots from fastbootd back into the bootloader.
+func (f *FastbootClient) RebootBootloader() (err error) {
+	output, err := f.runQuick("reboot", "bootloader")
+	if err != nil {
+		return NewFastbootError(output, err)
+	}
+	return nil
+}
+
+// RebootRecovery reboots from the bootloader into the recovery partition.
+func (f *FastbootClient) RebootRecovery() (err error) {
+	output, err := f.runQuick("reboot", "recovery")
 	if err != nil {
 		return NewFastbootError(output, err)
 	}
@@ -93,7 +390,7 @@ func (f *FastbootClient) Boot(image string) (err error) {
 }
 
 func (f *FastbootClient) Reboot() (err error) {
-	output, err := f.Run("reboot")
+	output, err := f.runQuick("reboot")
 	if err != nil {
 		return NewFastbootError(output, err)
 	}
@@ -113,7 +410,7 @@ func (f *FastbootClient) Unlocked() (bool, error) {
 		return "unlocked" == lockState, err
 	}
 
-	deviceInfo, err := f.Run("oem", "device-info")
+	deviceInfo, err := f.runQuick("oem", "device-info")
 	if err != nil {
 		return false, err
 	}
@@ -130,7 +427,7 @@ func (f *FastbootClient) Unlocked() (bool, error) {
 }
 
 func (f *FastbootClient) Unlock() (err error) {
-	output, err := f.Run("oem", "unlock")
+	output, err := f.runOnDevice("oem", "unlock")
 	if err != nil {
 		return NewFastbootError(output, err)
 	}
@@ -0,0 +1,24 @@
+package android
+
+import "testing"
+
+func TestSparseFailed(t *testing.T) {
+	tests := []struct {
+		output string
+		want   bool
+	}{
+		{"sending sparse 'system' 1/3 (524284 KB)...\nFAILED (remote: 'Invalid sparse file format at header magic')", true},
+		{"FAILED (remote: 'Requested download size is more than max allowed')", true},
+		{"FAILED (remote: 'data too large')", true},
+		{"FAILED (remote: 'Flashing is not allowed in Lock State')", false},
+		{"FAILED (remote: 'partition table doesn't exist')", false},
+		{"FAILED (Write to device failed (No such device))", false},
+		{"sending sparse 'system' 1/3 (524284 KB)...\nFAILED (Write to device failed (No such device))", false},
+		{"error: cannot load 'system.img': sparse file too large", true},
+	}
+	for _, tt := range tests {
+		if got := sparseFailed(tt.output); got != tt.want {
+			t.Errorf("sparseFailed(%q) = %v, want %v", tt.output, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package android
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// historySize is how many commands RecentCommands remembers.
+const historySize = 50
+
+// maxHistoryOutput is how much of the end of each command's output is kept.
+const maxHistoryOutput = 2000
+
+// historyEntry is a command that was run, with how often it was repeated in
+// a row with the same result since waiting on a device polls the same
+// command over and over.
+type historyEntry struct {
+	cmd, output, err string
+	repeats          int
+}
+
+var (
+	historyMu sync.Mutex
+	history   []historyEntry
+)
+
+// record adds a command run by tool to the history.
+func record(tool string, args []string, output string, err error) {
+	e := historyEntry{cmd: tool + " " + strings.Join(args, " "), output: output}
+	if len(e.output) > maxHistoryOutput {
+		e.output = "..." + e.output[len(e.output)-maxHistoryOutput:]
+	}
+	if err != nil {
+		e.err = err.Error()
+	}
+
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	if n := len(history); n > 0 {
+		last := &history[n-1]
+		if last.cmd == e.cmd && last.output == e.output && last.err == e.err {
+			last.repeats++
+			return
+		}
+	}
+	history = append(history, e)
+	if len(history) > historySize {
+		history = history[len(history)-historySize:]
+	}
+}
+
+// RecentCommands describes the last adb and fastboot commands run and their
+// output, oldest first, for bug reports.
+func RecentCommands() []string {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	var cmds []string
+	for _, e := range history {
+		s := "$ " + e.cmd
+		if e.repeats > 0 {
+			s += fmt.Sprintf(" (repeated %d more times)", e.repeats)
+		}
+		s += "\n" + e.output
+		if e.err != "" {
+			s += "\n[" + e.err + "]"
+		}
+		cmds = append(cmds, s)
+	}
+	return cmds
+}
@@ -0,0 +1,81 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package android
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// ORSPath is where TWRP looks for an OpenRecoveryScript.
+const ORSPath = "/cache/recovery/openrecoveryscript"
+
+// ErrORSUnsupported is returned by RunORS when TWRP is too old to run
+// scripts on demand.
+var ErrORSUnsupported = errors.New("TWRP can't run OpenRecoveryScripts")
+
+// RunORS runs commands in TWRP as one OpenRecoveryScript, which saves a round
+// trip over adb for each of them. Commands are written without the "twrp"
+// prefix, e.g. "wipe cache" or "install /sdcard/update.zip". The script's
+// output is streamed to the console and RunORS returns once it has finished.
+func RunORS(adb *AdbClient, commands []string) error {
+	if err := PushORS(adb, commands); err != nil {
+		return err
+	}
+
+	output, err := adb.RunStream(os.Stdout, "shell", "twrp runscript "+ORSPath)
+	if ORSUnsupported(output) {
+		return ErrORSUnsupported
+	}
+	if err != nil {
+		return NewAdbError(output, err)
+	}
+	return nil
+}
+
+// PushORS writes commands to the device as the OpenRecoveryScript that
+// "twrp runscript " + ORSPath runs.
+func PushORS(adb *AdbClient, commands []string) error {
+	f, err := ioutil.TempFile("", "openrecoveryscript")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(strings.Join(commands, "\n") + "\n")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = adb.Shell("mkdir -p /cache/recovery"); err != nil {
+		return err
+	}
+	if output, err := adb.Run("push", f.Name(), ORSPath); err != nil {
+		return NewAdbError(output, err)
+	}
+	return nil
+}
+
+// ORSUnsupported reports whether output from "twrp runscript" says TWRP is
+// too old to run scripts on demand.
+func ORSUnsupported(output string) bool {
+	return strings.Contains(strings.ToLower(output), "unrecognized")
+}
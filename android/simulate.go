@@ -0,0 +1,125 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package android
+
+import (
+	"io"
+	"strings"
+	"sync"
+)
+
+// simulator stands in for adb and fastboot, pretending to be a device that
+// succeeds at everything. It tracks which mode the device is in so the
+// install flow sees it move between the bootloader, TWRP and Android.
+type simulator struct {
+	mu      sync.Mutex
+	product string
+	// "bootloader", "recovery" or "system"
+	mode string
+	// the device is reported missing for this many more status checks,
+	// which is what a reboot looks like
+	rebooting int
+}
+
+// simulated is set by Simulate.
+var simulated *simulator
+
+// Simulate makes every AdbClient and FastbootClient talk to a simulated
+// device reporting product instead of running adb and fastboot, for trying
+// out device configs without the device.
+func Simulate(product string) {
+	simulated = &simulator{product: product, mode: "bootloader"}
+}
+
+func (s *simulator) reboot(mode string) {
+	s.mode = mode
+	s.rebooting = 1
+}
+
+// run returns what tool would have output for args.
+func (s *simulator) run(tool string, args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cmd := strings.Join(args, " ")
+	switch {
+	case cmd == "version" || cmd == "--version":
+		return "Version 99.0.0-simulated\n"
+
+	case cmd == "devices":
+		if s.rebooting > 0 {
+			s.rebooting--
+			return "List of devices attached\n\n"
+		}
+		present := s.mode == "bootloader"
+		if tool == "adb" {
+			present = !present
+		}
+		if !present {
+			return "List of devices attached\n\n"
+		}
+		if tool == "adb" {
+			return "List of devices attached\nSIMULATED\tdevice\n"
+		}
+		return "SIMULATED\tfastboot\n"
+
+	case tool == "fastboot" && len(args) == 2 && args[0] == "getvar":
+		switch args[1] {
+		case "product":
+			return "product: " + s.product + "\n"
+		case "unlocked":
+			return "unlocked: yes\n"
+		}
+		return args[1] + ":\n"
+
+	case tool == "adb" && cmd == "get-state":
+		if s.mode == "bootloader" || s.rebooting > 0 {
+			return "error: no devices/emulators found\n"
+		}
+		if s.mode == "system" {
+			return "device\n"
+		}
+		return s.mode + "\n"
+
+	case cmd == "oem device-info":
+		return "(bootloader) Device unlocked: true\n"
+
+	case cmd == "shell command -v twrp":
+		return "/sbin/twrp\n"
+
+	case strings.HasPrefix(cmd, "shell if [ -w"):
+		return "writable\n"
+
+	case strings.HasPrefix(cmd, "shell cat '/tmp/nh-"):
+		// detached jobs finish right away
+		return "0\n"
+
+	case cmd == "reboot bootloader", cmd == "reboot fastboot":
+		s.reboot("bootloader")
+	case cmd == "reboot recovery", tool == "fastboot" && len(args) > 0 && args[0] == "boot":
+		s.reboot("recovery")
+	case cmd == "reboot", cmd == "reboot ":
+		s.reboot("system")
+	}
+	return ""
+}
+
+func (s *simulator) runTo(w io.Writer, tool string, args []string) string {
+	output := s.run(tool, args)
+	io.WriteString(w, output)
+	return output
+}
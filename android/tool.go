@@ -17,8 +17,14 @@
 package android
 
 import (
+	"bytes"
+	"context"
+	"io"
 	"os"
 	"os/exec"
+	"regexp"
+	"strings"
+	"time"
 )
 
 // AndroidDeviceTool represents a program for interacting with Android devices.
@@ -35,21 +41,155 @@ const (
 	NoUsbPerms
 	DeviceUnauthorized
 	DeviceConnected
+	DeviceOffline
 )
 
+func (s AndroidDeviceStatus) String() string {
+	switch s {
+	case NoDeviceFound:
+		return "no device found"
+	case NoUsbPerms:
+		return "no usb permissions"
+	case DeviceUnauthorized:
+		return "device unauthorized"
+	case DeviceConnected:
+		return "device connected"
+	case DeviceOffline:
+		return "device offline"
+	}
+	return "unknown"
+}
+
+// transientErrors are printed by adb and fastboot when the connection to the
+// device drops rather than when the device rejects a command.
+var transientErrors = []string{
+	"no devices/emulators found",
+	"device offline",
+	"device not found",
+	"device still authorizing",
+	"protocol fault",
+	"connection reset",
+	"Broken pipe",
+	"status read failed",
+	"data transfer failure",
+	"Write to device failed",
+	"Read from device failed",
+}
+
+// IsTransient reports whether err from an AdbClient or FastbootClient was
+// caused by a lost connection, in which case the command may succeed if
+// retried.
+func IsTransient(err error) bool {
+	var output string
+	switch e := err.(type) {
+	case *AdbError:
+		output = e.Output
+	case *FastbootError:
+		output = e.Output
+	default:
+		return false
+	}
+
+	for _, s := range transientErrors {
+		if strings.Contains(output, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// toolsVersionRe matches the platform-tools version printed by "adb version"
+// and "fastboot --version", e.g. "Version 33.0.3-8952118".
+var toolsVersionRe = regexp.MustCompile(`(?m)^(?:fastboot )?[Vv]ersion (\S+)`)
+
+// toolsVersion returns the platform-tools version in output, or "" for tools
+// too old to print one.
+func toolsVersion(output string) string {
+	m := toolsVersionRe.FindStringSubmatch(output)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
 // BinaryAndroidTool represents an AndroidDeviceTool that is run as a binary program.
 type BinaryAndroidTool struct {
 	Name string
+
+	// Optional, kills any running command once done
+	Ctx context.Context
+}
+
+func (b *BinaryAndroidTool) context() context.Context {
+	if b.Ctx == nil {
+		return context.Background()
+	}
+	return b.Ctx
+}
+
+func (b *BinaryAndroidTool) command(args ...string) *exec.Cmd {
+	return exec.CommandContext(b.context(), b.Name, args...)
 }
 
 func (b *BinaryAndroidTool) Run(args ...string) (string, error) {
-	out, err := exec.Command(b.Name, args...).CombinedOutput()
+	if simulated != nil {
+		return simulated.run(b.Name, args), nil
+	}
+	out, err := b.command(args...).CombinedOutput()
+	record(b.Name, args, string(out), err)
+	return string(out), err
+}
+
+// RunTimeout is Run for commands that should finish within timeout. The
+// command is killed if it doesn't, returning context.DeadlineExceeded.
+func (b *BinaryAndroidTool) RunTimeout(timeout time.Duration, args ...string) (string, error) {
+	if simulated != nil {
+		return simulated.run(b.Name, args), nil
+	}
+	ctx, cancel := context.WithTimeout(b.context(), timeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, b.Name, args...).CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = ctx.Err()
+	}
+	record(b.Name, args, string(out), err)
 	return string(out), err
 }
 
 func (b *BinaryAndroidTool) RunFg(args ...string) error {
-	cmd := exec.Command(b.Name, args...)
+	if simulated != nil {
+		simulated.runTo(os.Stdout, b.Name, args)
+		return nil
+	}
+	cmd := b.command(args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	err := cmd.Run()
+	record(b.Name, args, "(output shown on the console)", err)
+	return err
+}
+
+// RunStream runs the tool and copies its combined output to w as it is
+// produced. The full output is also returned.
+func (b *BinaryAndroidTool) RunStream(w io.Writer, args ...string) (string, error) {
+	if simulated != nil {
+		return simulated.runTo(w, b.Name, args), nil
+	}
+	cmd := b.command(args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	cmd.Stderr = cmd.Stdout
+
+	if err = cmd.Start(); err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+	io.Copy(io.MultiWriter(w, &out), stdout)
+
+	err = cmd.Wait()
+	record(b.Name, args, out.String(), err)
+	return out.String(), err
 }
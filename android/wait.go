@@ -0,0 +1,88 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package android
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// WaitState is a device state that can be waited for.
+type WaitState uint8
+
+const (
+	// DevicePresent is reached as soon as the tool sees a device.
+	DevicePresent WaitState = iota
+	// DeviceAbsent is reached as soon as the tool no longer sees a device.
+	DeviceAbsent
+	// DeviceReappeared is reached once the device has gone away and come
+	// back, which is what a reboot looks like.
+	DeviceReappeared
+)
+
+// ErrWaitTimeout is returned when a device doesn't reach a WaitState in time.
+var ErrWaitTimeout = errors.New("timed out waiting for device")
+
+// PollInterval is how often device status is checked while waiting.
+var PollInterval = 500 * time.Millisecond
+
+// WaitUntil polls status until the device reaches state, timeout passes or ctx
+// is done. Errors from status count as the device being absent since the
+// tools fail while a device is re-enumerating.
+func WaitUntil(ctx context.Context, status func() (AndroidDeviceStatus, error), state WaitState, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	wentAway := false
+	for {
+		s, err := status()
+		present := err == nil && s != NoDeviceFound
+
+		switch state {
+		case DevicePresent:
+			if present {
+				return nil
+			}
+		case DeviceAbsent:
+			if !present {
+				return nil
+			}
+		case DeviceReappeared:
+			if !present {
+				wentAway = true
+			} else if wentAway {
+				return nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return ErrWaitTimeout
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(PollInterval):
+		}
+	}
+}
+
+func (a *AdbClient) WaitUntil(state WaitState, timeout time.Duration) error {
+	return WaitUntil(a.context(), a.Status, state, timeout)
+}
+
+func (f *FastbootClient) WaitUntil(state WaitState, timeout time.Duration) error {
+	return WaitUntil(f.context(), f.Status, state, timeout)
+}
@@ -0,0 +1,98 @@
+package android
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeStatus returns a status func that reports the device as present or
+// absent by the steps given, then sticks to the last one. The number of
+// calls made is stored in calls.
+func fakeStatus(calls *int, steps ...bool) func() (AndroidDeviceStatus, error) {
+	return func() (AndroidDeviceStatus, error) {
+		i := *calls
+		*calls++
+		if i >= len(steps) {
+			i = len(steps) - 1
+		}
+		if steps[i] {
+			return DeviceConnected, nil
+		}
+		return NoDeviceFound, nil
+	}
+}
+
+func TestWaitUntil(t *testing.T) {
+	defer func(interval time.Duration) { PollInterval = interval }(PollInterval)
+	PollInterval = time.Millisecond
+
+	tests := []struct {
+		name  string
+		state WaitState
+		steps []bool
+		calls int
+	}{
+		{"present right away", DevicePresent, []bool{true}, 1},
+		{"present later", DevicePresent, []bool{false, false, true}, 3},
+		{"absent right away", DeviceAbsent, []bool{false}, 1},
+		{"absent later", DeviceAbsent, []bool{true, false}, 2},
+		{"reappeared", DeviceReappeared, []bool{true, false, false, true}, 4},
+		{"reappeared after starting absent", DeviceReappeared, []bool{false, true}, 2},
+	}
+	for _, tt := range tests {
+		calls := 0
+		err := WaitUntil(context.Background(), fakeStatus(&calls, tt.steps...), tt.state, time.Second)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if calls != tt.calls {
+			t.Errorf("%s: checked status %d times, want %d", tt.name, calls, tt.calls)
+		}
+	}
+}
+
+func TestWaitUntilStatusError(t *testing.T) {
+	defer func(interval time.Duration) { PollInterval = interval }(PollInterval)
+	PollInterval = time.Millisecond
+
+	// tools failing while the device re-enumerates count as it being absent
+	calls := 0
+	status := func() (AndroidDeviceStatus, error) {
+		calls++
+		if calls == 1 {
+			return DeviceConnected, errors.New("device offline")
+		}
+		return DeviceConnected, nil
+	}
+	if err := WaitUntil(context.Background(), status, DeviceReappeared, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("checked status %d times, want 2", calls)
+	}
+}
+
+func TestWaitUntilTimeout(t *testing.T) {
+	defer func(interval time.Duration) { PollInterval = interval }(PollInterval)
+	PollInterval = time.Millisecond
+
+	for _, state := range []WaitState{DevicePresent, DeviceAbsent, DeviceReappeared} {
+		calls := 0
+		present := state != DevicePresent
+		err := WaitUntil(context.Background(), fakeStatus(&calls, present), state, 20*time.Millisecond)
+		if err != ErrWaitTimeout {
+			t.Errorf("state %d: got %v, want ErrWaitTimeout", state, err)
+		}
+	}
+}
+
+func TestWaitUntilCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	err := WaitUntil(ctx, fakeStatus(&calls, false), DevicePresent, time.Minute)
+	if err != context.Canceled {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
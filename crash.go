@@ -0,0 +1,50 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+
+	"./installer"
+)
+
+// crashLog is where a crash report is saved for attaching to bug reports.
+const crashLog = "installer-crash.log"
+
+// handlePanic reports a panic in main with its stack trace and exits through
+// exit so the window stays open on Windows. It must be deferred by main.
+func handlePanic() {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	report := fmt.Sprintf("Nethunter installer version %s %s/%s crashed: %v\n\n%s",
+		Version, runtime.GOOS, runtime.GOARCH, r, debug.Stack())
+	fmt.Fprintln(os.Stderr, report)
+	if err := ioutil.WriteFile(crashLog, []byte(report), 0644); err == nil {
+		if abs, err := filepath.Abs(crashLog); err == nil {
+			fmt.Fprintf(os.Stderr, "This was saved to %s, please include it when reporting the problem.\n", abs)
+		}
+	}
+	finish(installer.ResultOf(&installer.Error{Msg: fmt.Sprintf("Crashed: %v", r), Code: installer.ErrorCrash}))
+}
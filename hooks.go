@@ -0,0 +1,75 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+
+	"./installer"
+)
+
+// Commands run when the install finishes, set from --post-hook and
+// --fail-hook.
+var postHook, failHook string
+
+// hookSerial is the serial number of the device being installed, once known.
+var hookSerial string
+
+// runHook runs the hook for the outcome of the install, res, through the
+// system shell. The hook gets the outcome in its environment and its output
+// is echoed. A failing hook is reported but doesn't change the exit code.
+func runHook(res installer.Result) {
+	hook, result := postHook, "success"
+	if res.Failed() {
+		hook, result = failHook, "failure"
+	}
+	if hook == "" {
+		return
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", hook)
+	} else {
+		cmd = exec.Command("sh", "-c", hook)
+	}
+	cmd.Env = append(os.Environ(),
+		"NH_RESULT="+result,
+		"NH_EXIT_CODE="+strconv.Itoa(res.Code),
+		"NH_CATEGORY="+res.Category,
+		"NH_SERIAL="+hookSerial,
+	)
+	if reportDevice != nil {
+		cmd.Env = append(cmd.Env, "NH_DEVICE="+reportDevice.Common_name)
+	}
+	if res.Err != nil {
+		cmd.Env = append(cmd.Env, "NH_ERROR="+res.Err.Error())
+	}
+
+	iEcho("Running %s hook: %s", result, hook)
+	output, herr := cmd.CombinedOutput()
+	if len(output) > 0 {
+		fmt.Print(string(output))
+	}
+	if herr != nil {
+		iEcho("Warning: the %s hook failed: %s", result, herr.Error())
+	}
+}
@@ -0,0 +1,100 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package installer
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// AssetEntry describes a file an install downloads, with everything needed
+// to fetch and check it without the installer.
+type AssetEntry struct {
+	Name string `json:"name"`
+	File string `json:"file"`
+	// URL followed by the mirrors serving the same file
+	URLs []string `json:"urls"`
+	Size int64    `json:"size,omitempty"`
+	// From the release manifest or the device config, "" if unknown
+	Sha256   string `json:"sha256,omitempty"`
+	Optional bool   `json:"optional,omitempty"`
+}
+
+// FindDeviceByName returns the config of the device whose common name
+// (ignoring case) or product name is name.
+func FindDeviceByName(nhDevices Devices, name string) (Device, error) {
+	for _, d := range nhDevices.Device {
+		if strings.EqualFold(d.Common_name, name) || d.Product_name == name {
+			return d, nil
+		}
+	}
+	return Device{}, ErrNoDeviceConfig
+}
+
+// AssetsManifest lists every file an install on d downloads, for assembling
+// an --offline-dir bundle with other tools. When d has a release manifest
+// it is fetched for the sums and listed too, since offline installs check
+// against it.
+func AssetsManifest(ctx context.Context, d Device, opts Options) ([]AssetEntry, error) {
+	in := newInstall(ctx, d, opts)
+	if d.Manifest_url != "" {
+		if err := in.fetchManifest(); err != nil {
+			return nil, err
+		}
+	}
+	gappsSums := make(map[string]string)
+	for _, g := range d.Gapps {
+		gappsSums[g.File] = strings.ToLower(g.Sha256)
+	}
+
+	var entries []AssetEntry
+	for _, a := range d.Assets() {
+		sum := in.expectedSum(a)
+		if sum == "" {
+			sum = gappsSums[a.File]
+		}
+		entries = append(entries, AssetEntry{a.Name, a.File, in.mirrorURLs(a.URL), a.Size, sum, a.Optional})
+	}
+	if d.Manifest_url != "" {
+		manifest := path.Base(d.Manifest_url)
+		entries = append(entries,
+			AssetEntry{"release manifest", manifest, []string{d.Manifest_url}, 0, "", false},
+			AssetEntry{"manifest signature", manifest + ".gpg", []string{d.Manifest_url + ".gpg"}, 0, "", false},
+		)
+	}
+	return entries, nil
+}
+
+func (e AssetEntry) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s (%s)", e.File, e.Name)
+	if e.Optional {
+		b.WriteString(", optional")
+	}
+	if e.Size > 0 {
+		fmt.Fprintf(&b, "\n    %-8s %d bytes", "size:", e.Size)
+	}
+	if e.Sha256 != "" {
+		fmt.Fprintf(&b, "\n    %-8s %s", "sha256:", e.Sha256)
+	}
+	for _, u := range e.URLs {
+		fmt.Fprintf(&b, "\n    %-8s %s", "url:", u)
+	}
+	return b.String()
+}
@@ -0,0 +1,86 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package installer
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+
+	"../remote"
+)
+
+// Files in a config bundle, a zip holding the devices config as JSON along
+// with a SHA256SUMS listing it and the detached signature of that.
+const (
+	bundleConfig    = "devices.json"
+	bundleSums      = "SHA256SUMS"
+	bundleSignature = "SHA256SUMS.gpg"
+)
+
+// LoadBundle reads the devices config from the config bundle at path once it
+// has checked that the bundle was signed with a key from keyPath. This lets
+// device configs be updated separately from the installer.
+func LoadBundle(path, keyPath string) (Devices, error) {
+	var nhConfig Devices
+	keyring, err := readKeyring(keyPath)
+	if err != nil {
+		return nhConfig, fmt.Errorf("failed to read bundle key: %v", err)
+	}
+
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return nhConfig, err
+	}
+	defer r.Close()
+
+	files := make(map[string][]byte)
+	for _, f := range r.File {
+		switch f.Name {
+		case bundleConfig, bundleSums, bundleSignature:
+		default:
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nhConfig, err
+		}
+		files[f.Name], err = ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nhConfig, err
+		}
+	}
+	for _, name := range []string{bundleConfig, bundleSums, bundleSignature} {
+		if _, ok := files[name]; !ok {
+			return nhConfig, fmt.Errorf("bundle is missing %s", name)
+		}
+	}
+
+	sums, err := remote.CheckManifest(files[bundleSums], files[bundleSignature], keyring)
+	if err != nil {
+		return nhConfig, err
+	}
+	sum := sha256.Sum256(files[bundleConfig])
+	if expected, actual := sums[bundleConfig], hex.EncodeToString(sum[:]); actual != expected {
+		return nhConfig, fmt.Errorf("%s is corrupt (sha256 %s, expected %s)", bundleConfig, actual, expected)
+	}
+
+	return ParseDevicesConfig(files[bundleConfig], ConfigJSON)
+}
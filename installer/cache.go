@@ -0,0 +1,119 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package installer
+
+import (
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// cachePath returns where a file with the given SHA-256 sum is kept in the
+// shared download cache.
+func (in *install) cachePath(sum string) string {
+	return filepath.Join(in.opts.CacheDir, sum)
+}
+
+// fromCache links the assets the shared download cache has a good copy of
+// into the working directory and returns the ones it doesn't have. Only
+// assets with a known sum can be looked up, since the cache is keyed by it.
+func (in *install) fromCache(assets []Asset) (missing []Asset) {
+	if in.opts.CacheDir == "" {
+		return assets
+	}
+	for _, a := range assets {
+		expected := in.expectedSum(a)
+		if expected == "" || in.opts.ForceDownload {
+			missing = append(missing, a)
+			continue
+		}
+		cached := in.cachePath(expected)
+		if _, err := os.Stat(cached); err != nil {
+			missing = append(missing, a)
+			continue
+		}
+		// the cache outlives any one run, so it is checked before every use
+		if sum, err := fileHash(cached, sha256.New()); err != nil || sum != expected {
+			in.echo("Warning: removing a corrupt copy of %s from the download cache", a.File)
+			os.Remove(cached)
+			missing = append(missing, a)
+			continue
+		}
+		if err := linkOrCopy(cached, a.File); err != nil {
+			in.echo("Warning: failed to use %s from the download cache: %s", a.File, err.Error())
+			missing = append(missing, a)
+			continue
+		}
+		in.echo("Using %s from the download cache", a.File)
+		in.sums[a.File] = expected
+	}
+	return missing
+}
+
+// toCache adds the freshly downloaded file of a to the shared download
+// cache. Failing to is only worth a warning, the download itself is fine.
+func (in *install) toCache(a Asset) {
+	sum, ok := in.sums[a.File]
+	if in.opts.CacheDir == "" || !ok || sum != in.expectedSum(a) {
+		return
+	}
+	cached := in.cachePath(sum)
+	if _, err := os.Stat(cached); err == nil {
+		return
+	}
+	err := os.MkdirAll(in.opts.CacheDir, 0755)
+	if err == nil {
+		err = linkOrCopy(a.File, cached)
+	}
+	if err != nil {
+		in.echo("Warning: failed to add %s to the download cache: %s", a.File, err.Error())
+	}
+}
+
+// linkOrCopy hard links src to dst, copying it instead when they are on
+// different filesystems. dst is written under a temporary name first so a
+// partial copy is never mistaken for the real thing.
+func linkOrCopy(src, dst string) error {
+	os.Remove(dst)
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
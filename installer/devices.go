@@ -0,0 +1,559 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package installer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"../android"
+	"../remote"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Device is the configuration for installing on one device model.
+type Device struct {
+	Common_name  string
+	Product_name string
+
+	// Optional, for telling apart devices that share a product name
+	Variant string
+	// Optional fastboot serial number, for a config written for one
+	// particular device. It takes precedence over configs without one.
+	Serialno string
+
+	// Optional product name the bootloader reports instead of product_name
+	// when the device shares one with others, e.g. QC_Reference_Phone on
+	// OnePlus devices
+	Shared_product string
+	// Optional fastboot variable and the value it has on this device, to pick
+	// it out of the devices with the same shared_product without asking
+	Hint_var string
+	Hint     string
+
+	// "rom" (the default) installs NethunterOS through TWRP, "boot" only
+	// flashes a Nethunter kernel boot image
+	Install_type string
+
+	// Optional "_size" fields are the expected sizes of the files in bytes
+	// (after decompression), to catch truncated downloads
+
+	Nhos_file string
+	Nhos_url  string
+	Nhos_size int64
+
+	Nhfs_file string
+	Nhfs_url  string
+	Nhfs_size int64
+
+	// Google Apps variants the user can choose between
+	Gapps []GappsOption
+
+	Twrp_file string
+	Twrp_url  string
+	Twrp_size int64
+	// Optional SHA-256 of the TWRP image, checked after downloading and
+	// again before it is flashed or booted
+	Twrp_sha256 string
+	// "flash+boot" (the default) flashes TWRP to recovery and boots it,
+	// "flash" only reboots into the flashed recovery and "boot" only
+	// temporarily boots the image, leaving recovery alone
+	Twrp_mode string
+
+	Extra_file string
+	Extra_url  string
+	Extra_size int64
+	// Carry on without the extra zip when it can't be downloaded
+	Extra_optional bool
+	// "zip" (the default) installs the extra zip in TWRP, "images" flashes
+	// the images in it with fastboot, to the partitions in extra_images
+	Extra_mode string
+	// Image in an "images" extra zip to flash, by partition
+	Extra_images map[string]string
+
+	// Optional images flashed to the dtbo and vendor_boot partitions, which
+	// newer devices need to boot
+	Dtbo_file string
+	Dtbo_url  string
+	Dtbo_size int64
+
+	Vendor_boot_file string
+	Vendor_boot_url  string
+	Vendor_boot_size int64
+
+	// Patched boot image, for "boot" installs
+	Boot_file string
+	Boot_url  string
+	Boot_size int64
+
+	// Optional Android API level NethunterOS is built on
+	Android_sdk int
+
+	// Optional signed SHA256SUMS listing the assets of this release
+	Manifest_url string
+
+	// Optional oldest bootloader the ROM boots with, as reported by
+	// "fastboot getvar version-bootloader"
+	Min_bootloader string
+
+	// Partitions passed to "twrp wipe", in order, before and after installing
+	Wipe      []string
+	Post_wipe []string
+
+	// Format data before pushing, for devices where TWRP can't decrypt it
+	Format_data bool
+
+	// Only flash the device while it is plugged into power
+	Require_charging bool
+
+	// Dynamic partitions that have to be flashed from fastbootd
+	Fastbootd_partitions []string
+
+	// TWRP commands run before pushing the zips and after installing them,
+	// for one-off device quirks
+	Pre_install_cmds  []string
+	Post_install_cmds []string
+}
+
+// Install types.
+const (
+	installTypeROM  = "rom"
+	installTypeBoot = "boot"
+)
+
+// Extra modes.
+const (
+	extraModeZip    = "zip"
+	extraModeImages = "images"
+)
+
+// TWRP modes.
+const (
+	twrpModeFlashBoot = "flash+boot"
+	twrpModeFlash     = "flash"
+	twrpModeBoot      = "boot"
+)
+
+// flashesTwrp reports whether TWRP may be flashed to d's recovery partition.
+func (d Device) flashesTwrp() bool {
+	return d.Twrp_mode != twrpModeBoot
+}
+
+// bootsTwrp reports whether the TWRP image may be booted on d with fastboot.
+func (d Device) bootsTwrp() bool {
+	return d.Twrp_mode != twrpModeFlash
+}
+
+// extraZip reports whether d has an extra zip to install in TWRP.
+func (d Device) extraZip() bool {
+	return d.Extra_file != "" && d.Extra_mode != extraModeImages
+}
+
+// bootOnly reports whether d only needs its boot image flashed.
+func (d Device) bootOnly() bool {
+	return d.Install_type == installTypeBoot
+}
+
+// overrideURLs replaces d's URLs with any set in the environment, for
+// testing new builds without editing the config. Files are renamed after the
+// new URL so a cached copy of the shipped file isn't used instead. It returns
+// the overridden variables.
+func (d *Device) overrideURLs() []string {
+	urls := []struct {
+		env       string
+		url, file *string
+	}{
+		{"NH_NHOS_URL", &d.Nhos_url, &d.Nhos_file},
+		{"NH_NHFS_URL", &d.Nhfs_url, &d.Nhfs_file},
+		{"NH_TWRP_URL", &d.Twrp_url, &d.Twrp_file},
+		{"NH_EXTRA_URL", &d.Extra_url, &d.Extra_file},
+		{"NH_BOOT_URL", &d.Boot_url, &d.Boot_file},
+		{"NH_DTBO_URL", &d.Dtbo_url, &d.Dtbo_file},
+		{"NH_VENDOR_BOOT_URL", &d.Vendor_boot_url, &d.Vendor_boot_file},
+		{"NH_MANIFEST_URL", &d.Manifest_url, nil},
+	}
+
+	var overridden []string
+	for _, u := range urls {
+		v := os.Getenv(u.env)
+		if v == "" {
+			continue
+		}
+		*u.url = v
+		if u.file != nil {
+			file := path.Base(v)
+			if remote.IsCompressed(v) {
+				file = strings.TrimSuffix(file, path.Ext(file))
+			}
+			*u.file = file
+		}
+		overridden = append(overridden, u.env+"="+v)
+	}
+	return overridden
+}
+
+// needsFastbootd reports whether partition has to be flashed from fastbootd.
+func (d Device) needsFastbootd(partition string) bool {
+	for _, p := range d.Fastbootd_partitions {
+		if p == partition {
+			return true
+		}
+	}
+	return false
+}
+
+// GappsOption is one Google Apps variant (nano, pico, full...) of a device.
+type GappsOption struct {
+	Name string
+	File string
+	Url  string
+
+	// Optional hex encoded SHA-256 sum and size of File
+	Sha256 string
+	Size   int64
+
+	// Carry on without Google Apps when they can't be downloaded
+	Optional bool
+}
+
+// assetName is what the option is called in install plans and summaries.
+func (g GappsOption) assetName() string {
+	return "Google Apps (" + g.Name + ")"
+}
+
+// gappsOption returns the Google Apps variant called name, if d has one.
+func (d Device) gappsOption(name string) (GappsOption, bool) {
+	for _, g := range d.Gapps {
+		if g.Name == name {
+			return g, true
+		}
+	}
+	return GappsOption{}, false
+}
+
+var (
+	defaultWipe     = []string{"dalvik", "data", "system"}
+	defaultPostWipe = []string{"cache", "dalvik"}
+)
+
+// Asset is a file needed for the install along with where to get it.
+type Asset struct {
+	Name string `json:"name"`
+	File string `json:"file"`
+	URL  string `json:"url"`
+	// Expected size of File, or 0 if unknown
+	Size int64 `json:"size,omitempty"`
+	// The install carries on without it if it can't be downloaded
+	Optional bool `json:"optional,omitempty"`
+}
+
+// Assets returns the files needed to install on d in the order they should
+// be downloaded, including every Google Apps variant.
+func (d Device) Assets() []Asset {
+	if d.bootOnly() {
+		return []Asset{{"Nethunter kernel", d.Boot_file, d.Boot_url, d.Boot_size, false}}
+	}
+
+	var assets []Asset
+	if d.Extra_file != "" && d.Extra_url != "" {
+		assets = append(assets, Asset{"extra zip", d.Extra_file, d.Extra_url, d.Extra_size, d.Extra_optional})
+	}
+	assets = append(assets,
+		Asset{"NethunterOS", d.Nhos_file, d.Nhos_url, d.Nhos_size, false},
+		Asset{"Nethunter filesystem", d.Nhfs_file, d.Nhfs_url, d.Nhfs_size, false},
+	)
+	for _, g := range d.Gapps {
+		assets = append(assets, Asset{g.assetName(), g.File, g.Url, g.Size, g.Optional})
+	}
+	assets = append(assets, Asset{"TWRP", d.Twrp_file, d.Twrp_url, d.Twrp_size, false})
+	for _, p := range d.partitionImages() {
+		assets = append(assets, Asset{p.name, p.file, p.url, p.size, false})
+	}
+	return assets
+}
+
+// partitionImage is an image flashed to a partition of its own as part of a
+// "rom" install.
+type partitionImage struct {
+	partition, name string
+	file, url       string
+	size            int64
+}
+
+// partitionImages returns the partition images d needs flashed, in order.
+func (d Device) partitionImages() []partitionImage {
+	var images []partitionImage
+	if d.Dtbo_file != "" && d.Dtbo_url != "" {
+		images = append(images, partitionImage{"dtbo", "dtbo image", d.Dtbo_file, d.Dtbo_url, d.Dtbo_size})
+	}
+	if d.Vendor_boot_file != "" && d.Vendor_boot_url != "" {
+		images = append(images, partitionImage{"vendor_boot", "vendor_boot image", d.Vendor_boot_file, d.Vendor_boot_url, d.Vendor_boot_size})
+	}
+	return images
+}
+
+// Devices is the devices config file.
+type Devices struct {
+	// Armored public key(s) that device manifests are signed with
+	Manifest_key string
+
+	// Alternative URL prefixes to download from, by the prefix they replace
+	Mirrors map[string][]string
+
+	// Never offer Google Apps, for builds that shouldn't ship them
+	Hide_gapps bool
+
+	// Values for every device that doesn't set them itself
+	Defaults Device
+
+	Device []Device
+}
+
+// Config formats, see ParseDevicesConfig.
+const (
+	ConfigTOML = "toml"
+	ConfigJSON = "json"
+)
+
+// ReadDevicesConfig reads the devices config at path, filling in defaults.
+// Files ending in ".json" are read as JSON and anything else as TOML.
+func ReadDevicesConfig(path string) (Devices, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Devices{}, err
+	}
+
+	format := ConfigTOML
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		format = ConfigJSON
+	}
+	return ParseDevicesConfig(b, format)
+}
+
+// ParseDevicesConfig parses a devices config in format, filling in defaults.
+// Both formats use the same field names, e.g. "common_name".
+func ParseDevicesConfig(b []byte, format string) (Devices, error) {
+	var nhConfig Devices
+	var err error
+	switch format {
+	case ConfigTOML:
+		_, err = toml.Decode(string(b), &nhConfig)
+	case ConfigJSON:
+		err = json.Unmarshal(b, &nhConfig)
+	default:
+		err = fmt.Errorf("unknown config format %q", format)
+	}
+	if err != nil {
+		return nhConfig, err
+	}
+	return nhConfig, nhConfig.setDefaults()
+}
+
+// inherit fills in every field d leaves unset from defaults. Booleans can
+// only be turned on this way, since false looks unset.
+func (d *Device) inherit(defaults Device) {
+	dv := reflect.ValueOf(d).Elem()
+	defv := reflect.ValueOf(defaults)
+	for i := 0; i < dv.NumField(); i++ {
+		f := dv.Field(i)
+		if isZero(f) {
+			f.Set(defv.Field(i))
+		}
+	}
+}
+
+// isZero reports whether v holds its type's zero value. Only nil lists are
+// unset, an explicitly empty one (e.g. wipe = []) is kept.
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.IsNil()
+	}
+	return v.Interface() == reflect.Zero(v.Type()).Interface()
+}
+
+// checkRequired makes sure d has the fields its install type can't do
+// without.
+func (d Device) checkRequired() error {
+	required := map[string]string{
+		"common_name":  d.Common_name,
+		"product_name": d.Product_name,
+	}
+	if d.bootOnly() {
+		required["boot_file"] = d.Boot_file
+		required["boot_url"] = d.Boot_url
+	} else {
+		required["nhos_file"] = d.Nhos_file
+		required["nhos_url"] = d.Nhos_url
+		required["nhfs_file"] = d.Nhfs_file
+		required["nhfs_url"] = d.Nhfs_url
+		required["twrp_file"] = d.Twrp_file
+		required["twrp_url"] = d.Twrp_url
+	}
+
+	var missing []string
+	for field, value := range required {
+		if value == "" {
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("%s: missing %s", d.Common_name, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// setDefaults fills in defaults for every device, first from the config's
+// own defaults, and checks the values that can't be left to fail during an
+// install.
+func (c *Devices) setDefaults() error {
+	for i := range c.Device {
+		d := &c.Device[i]
+		d.inherit(c.Defaults)
+		if d.Wipe == nil {
+			d.Wipe = defaultWipe
+		}
+		if d.Post_wipe == nil {
+			d.Post_wipe = defaultPostWipe
+		}
+		switch d.Install_type {
+		case "":
+			d.Install_type = installTypeROM
+		case installTypeROM, installTypeBoot:
+		default:
+			return fmt.Errorf("%s: unknown install_type %q", d.Common_name, d.Install_type)
+		}
+		switch d.Extra_mode {
+		case "":
+			d.Extra_mode = extraModeZip
+		case extraModeZip:
+		case extraModeImages:
+			if d.Extra_file == "" || len(d.Extra_images) == 0 {
+				return fmt.Errorf("%s: extra_mode %q needs an extra_file and extra_images", d.Common_name, d.Extra_mode)
+			}
+		default:
+			return fmt.Errorf("%s: unknown extra_mode %q", d.Common_name, d.Extra_mode)
+		}
+		switch d.Twrp_mode {
+		case "":
+			d.Twrp_mode = twrpModeFlashBoot
+		case twrpModeFlashBoot, twrpModeFlash, twrpModeBoot:
+		default:
+			return fmt.Errorf("%s: unknown twrp_mode %q", d.Common_name, d.Twrp_mode)
+		}
+		for _, cmd := range append(d.Pre_install_cmds, d.Post_install_cmds...) {
+			if err := checkInstallCmd(cmd); err != nil {
+				return fmt.Errorf("%s: %v", d.Common_name, err)
+			}
+		}
+		if err := d.checkRequired(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// installCmdPrefixes are the programs device configs may run in TWRP.
+var installCmdPrefixes = []string{"twrp", "mount", "umount"}
+
+// checkInstallCmd makes sure cmd from a device config runs one of
+// installCmdPrefixes and can't chain anything else onto it.
+func checkInstallCmd(cmd string) error {
+	if strings.ContainsAny(cmd, ";&|`$<>\n") {
+		return fmt.Errorf("install command %q must be a single command", cmd)
+	}
+
+	fields := strings.Fields(cmd)
+	if len(fields) > 0 {
+		for _, p := range installCmdPrefixes {
+			if fields[0] == p {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("install command %q must start with one of %s", cmd, strings.Join(installCmdPrefixes, ", "))
+}
+
+// identityMatches reports whether the optional variant and serial number of
+// d, where set, match the device identified by id.
+func identityMatches(id android.DeviceIdentity, d Device) bool {
+	if d.Variant != "" && d.Variant != id.Variant {
+		return false
+	}
+	return d.Serialno == "" || d.Serialno == id.Serialno
+}
+
+// deviceMatchesConfig reports whether the device identified by id may be
+// flashed with d.
+func deviceMatchesConfig(id android.DeviceIdentity, d Device) bool {
+	if !identityMatches(id, d) {
+		return false
+	}
+	// devices sharing a product name were picked by hint or by the user
+	return d.Product_name == id.Product || (d.Shared_product != "" && d.Shared_product == id.Product)
+}
+
+// ErrNoDeviceConfig is returned by FindDeviceConfig when no config matches.
+var ErrNoDeviceConfig = errors.New("no config for this device")
+
+// FindDeviceConfig returns the config for the device identified by id, or
+// ErrNoDeviceConfig if there is none. A config for the device's serial number
+// wins over one for its model.
+func FindDeviceConfig(nhDevices Devices, id android.DeviceIdentity) (Device, error) {
+	var match *Device
+	for i, d := range nhDevices.Device {
+		if d.Product_name != id.Product || !identityMatches(id, d) {
+			continue
+		}
+		if d.Serialno != "" {
+			return d, nil
+		}
+		if match == nil {
+			match = &nhDevices.Device[i]
+		}
+	}
+	if match == nil {
+		return Device{}, ErrNoDeviceConfig
+	}
+	return *match, nil
+}
+
+// sharedProductCandidates returns the configs of the devices that report
+// id's product name as their shared_product.
+func sharedProductCandidates(nhDevices Devices, id android.DeviceIdentity) []Device {
+	var candidates []Device
+	for _, d := range nhDevices.Device {
+		if d.Shared_product == "" || d.Shared_product != id.Product {
+			continue
+		}
+		if !identityMatches(id, d) {
+			continue
+		}
+		candidates = append(candidates, d)
+	}
+	return candidates
+}
@@ -0,0 +1,84 @@
+package installer
+
+import (
+	"reflect"
+	"testing"
+
+	"../android"
+)
+
+const testDevice = `"common_name": "Test", "product_name": "test",
+	"nhos_file": "nhos.zip", "nhos_url": "https://example.com/nhos.zip",
+	"nhfs_file": "nhfs.zip", "nhfs_url": "https://example.com/nhfs.zip",
+	"twrp_file": "twrp.img", "twrp_url": "https://example.com/twrp.img"`
+
+func TestWipeLists(t *testing.T) {
+	tests := []struct {
+		name           string
+		config         string
+		wipe, postWipe []string
+	}{
+		{
+			"unset",
+			`{"device": [{` + testDevice + `}]}`,
+			defaultWipe, defaultPostWipe,
+		},
+		{
+			"explicitly empty",
+			`{"device": [{` + testDevice + `, "wipe": [], "post_wipe": []}]}`,
+			[]string{}, []string{},
+		},
+		{
+			"explicitly empty over defaults",
+			`{"defaults": {"wipe": ["system"], "post_wipe": ["cache"]},
+			"device": [{` + testDevice + `, "wipe": [], "post_wipe": []}]}`,
+			[]string{}, []string{},
+		},
+		{
+			"from defaults",
+			`{"defaults": {"wipe": ["system"], "post_wipe": ["cache"]},
+			"device": [{` + testDevice + `}]}`,
+			[]string{"system"}, []string{"cache"},
+		},
+	}
+	for _, tt := range tests {
+		c, err := ParseDevicesConfig([]byte(tt.config), ConfigJSON)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		d := c.Device[0]
+		if !reflect.DeepEqual(d.Wipe, tt.wipe) || !reflect.DeepEqual(d.Post_wipe, tt.postWipe) {
+			t.Errorf("%s: got wipe %q, post_wipe %q, want %q, %q", tt.name, d.Wipe, d.Post_wipe, tt.wipe, tt.postWipe)
+		}
+	}
+}
+
+func TestFindDeviceConfig(t *testing.T) {
+	nhDevices := Devices{Device: []Device{
+		{Common_name: "Phone", Product_name: "phone"},
+		{Common_name: "Phone Pro", Product_name: "shared", Variant: "pro"},
+		{Common_name: "Phone Lite", Product_name: "shared", Variant: "lite"},
+		{Common_name: "Phone (test unit)", Product_name: "phone", Serialno: "0123abcd"},
+	}}
+	tests := []struct {
+		name string
+		id   android.DeviceIdentity
+		want string
+		err  error
+	}{
+		{"match", android.DeviceIdentity{Product: "phone"}, "Phone", nil},
+		{"match ignoring variant", android.DeviceIdentity{Product: "phone", Variant: "any"}, "Phone", nil},
+		{"variant match", android.DeviceIdentity{Product: "shared", Variant: "lite"}, "Phone Lite", nil},
+		{"variant mismatch", android.DeviceIdentity{Product: "shared", Variant: "max"}, "", ErrNoDeviceConfig},
+		{"serial match", android.DeviceIdentity{Product: "phone", Serialno: "0123abcd"}, "Phone (test unit)", nil},
+		{"serial mismatch", android.DeviceIdentity{Product: "phone", Serialno: "ffff"}, "Phone", nil},
+		{"no match", android.DeviceIdentity{Product: "unknown"}, "", ErrNoDeviceConfig},
+	}
+	for _, tt := range tests {
+		d, err := FindDeviceConfig(nhDevices, tt.id)
+		if err != tt.err || d.Common_name != tt.want {
+			t.Errorf("%s: got %q, %v, want %q, %v", tt.name, d.Common_name, err, tt.want, tt.err)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package installer
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+
+	"../android"
+	"../remote"
+)
+
+// minFreeSpace is enough room for a ROM, filesystem, gapps and TWRP, for
+// when the download sizes aren't known.
+const minFreeSpace = 4 << 30
+
+// downloadSize adds up the sizes of assets, from the config where it gives
+// them and otherwise as the server reports them. Sizes that aren't known
+// count as nothing.
+func (in *install) downloadSize(assets []Asset) int64 {
+	var total int64
+	for _, a := range assets {
+		size := a.Size
+		if size <= 0 {
+			size, _ = remote.AssetSize(in.ctx, a.URL)
+		}
+		if size > 0 {
+			total += size
+		}
+	}
+	return total
+}
+
+// checkDiskSpace makes sure assets fit on the disk before any of them are
+// downloaded.
+func (in *install) checkDiskSpace(assets []Asset) error {
+	need := in.downloadSize(assets)
+	if need == 0 {
+		return nil
+	}
+	free, err := diskFree(".")
+	if err != nil {
+		in.echo("Warning: couldn't check free disk space: %s", err.Error())
+		return nil
+	}
+	if need > free {
+		return newError(fmt.Sprintf("Not enough disk space for the downloads, %s needed but only %s free", formatBytes(need), formatBytes(free)), ErrorPrereqs, nil)
+	}
+	return nil
+}
+
+// notDownloaded returns the assets whose files aren't in the working
+// directory yet.
+func notDownloaded(assets []Asset) []Asset {
+	var missing []Asset
+	for _, a := range assets {
+		if _, err := os.Stat(a.File); os.IsNotExist(err) {
+			missing = append(missing, a)
+		}
+	}
+	return missing
+}
+
+// CheckResult is the outcome of one of the Doctor checks.
+type CheckResult struct {
+	Name   string
+	OK     bool
+	Detail string
+}
+
+// Doctor checks that everything an install needs is in place without
+// touching the device: the tools, the device connection, the download
+// servers and disk space.
+func Doctor(ctx context.Context, nhDevices Devices, opts Options) []CheckResult {
+	in := newInstall(ctx, Device{}, opts)
+	var results []CheckResult
+	add := func(name string, ok bool, format string, a ...interface{}) {
+		results = append(results, CheckResult{name, ok, fmt.Sprintf(format, a...)})
+	}
+
+	tools := []struct {
+		name    string
+		version func() (string, error)
+	}{
+		{"adb", in.adb.Version},
+		{"fastboot", in.fastboot.Version},
+	}
+	for _, t := range tools {
+		version, err := t.version()
+		switch {
+		case err != nil:
+			add(t.name, false, "failed to run: %v", err)
+		case version == "" || compareVersions(version, minToolsVersion) < 0:
+			add(t.name, false, "%s is older than %s", orUnknown(version), minToolsVersion)
+		default:
+			add(t.name, true, "%s", version)
+		}
+	}
+
+	adbStatus, _ := in.adb.Status()
+	fastbootStatus, _ := in.fastboot.Status()
+	switch {
+	case adbStatus == android.NoUsbPerms || fastbootStatus == android.NoUsbPerms:
+		add("device", false, "no USB permissions, see the installer README")
+	case adbStatus == android.DeviceUnauthorized:
+		add("device", false, "connected but USB debugging isn't authorized yet")
+	case fastbootStatus == android.DeviceConnected:
+		add("device", true, "connected in the bootloader")
+	case adbStatus == android.DeviceConnected:
+		add("device", true, "connected over adb")
+	default:
+		add("device", false, "no device found")
+	}
+
+	// one URL per server is enough to tell whether it can be reached
+	checked := make(map[string]bool)
+	for _, d := range nhDevices.Device {
+		for _, a := range d.Assets() {
+			for _, dlLink := range in.mirrorURLs(a.URL) {
+				u, err := url.Parse(dlLink)
+				if err != nil || checked[u.Host] {
+					continue
+				}
+				checked[u.Host] = true
+				if err := remote.CheckURL(ctx, dlLink); err != nil {
+					add(u.Host, false, "%v", err)
+				} else {
+					add(u.Host, true, "reachable")
+				}
+			}
+		}
+	}
+
+	// enough for whichever device needs the most still downloaded
+	var need int64
+	toDownload := false
+	for _, d := range nhDevices.Device {
+		missing := notDownloaded(d.Assets())
+		toDownload = toDownload || len(missing) > 0
+		if size := in.downloadSize(missing); size > need {
+			need = size
+		}
+	}
+	if toDownload && need == 0 {
+		need = minFreeSpace
+	}
+	if free, err := diskFree("."); err != nil {
+		add("disk space", false, "%v", err)
+	} else {
+		add("disk space", free >= need, "%s free, %s needed", formatBytes(free), formatBytes(need))
+	}
+	return results
+}
@@ -0,0 +1,109 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package installer
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"../remote"
+)
+
+// downloadsRecord lists the files the installer downloaded itself, so
+// cleaning up never touches files the user put in the installer dir.
+const downloadsRecord = ".downloads"
+
+// readDownloads returns the files recorded as downloaded by the installer.
+func readDownloads() ([]string, error) {
+	f, err := os.Open(downloadsRecord)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var files []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if file := strings.TrimSpace(scanner.Text()); file != "" {
+			files = append(files, file)
+		}
+	}
+	return files, scanner.Err()
+}
+
+// recordDownload remembers that file was downloaded by the installer.
+func recordDownload(file string) error {
+	files, err := readDownloads()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f == file {
+			return nil
+		}
+	}
+
+	f, err := os.OpenFile(downloadsRecord, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintln(f, file)
+	return err
+}
+
+// cleanDownloads removes every file the installer downloaded and returns the
+// number of bytes reclaimed.
+func cleanDownloads() (reclaimed int64, err error) {
+	files, err := readDownloads()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return reclaimed, err
+		}
+		if err = os.Remove(file); err != nil {
+			return reclaimed, err
+		}
+		reclaimed += info.Size()
+		remote.ForgetValidators(file)
+	}
+	return reclaimed, os.Remove(downloadsRecord)
+}
+
+// formatBytes formats n bytes for humans.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,123 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package installer
+
+import (
+	"errors"
+)
+
+// Exit codes, keep in sync with tests/functional.sh.
+
+const (
+	// Success exit codes.
+	SuccessBase = 1<<5 + iota
+	SuccessUserAbort
+	SuccessBootloaderUnlocked
+
+	Success = 0
+)
+
+const (
+	// Error exit codes.
+	ErrorBase = 1<<6 + iota
+	ErrorPrereqs
+	ErrorUserInput
+	ErrorUsbPerms
+	ErrorAdb
+	ErrorFastboot
+	ErrorRemote
+	ErrorTWRP
+	ErrorTimeout
+	ErrorCrash
+	ErrorNoDeviceConfig
+	ErrorVerify
+)
+
+// Error is a failed install step along with the exit code it maps to.
+type Error struct {
+	Msg  string
+	Code int
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Msg
+	}
+	return e.Msg + ": " + e.Err.Error()
+}
+
+func newError(msg string, code int, err error) *Error {
+	return &Error{msg, code, err}
+}
+
+// Result is how a run of the installer ends.
+type Result struct {
+	// Exit code
+	Code int
+	// Short name for the kind of outcome, e.g. "fastboot" or "download"
+	Category string
+	// What went wrong, nil on success
+	Err error
+}
+
+// Failed reports whether r is a failure rather than one of the successes.
+func (r Result) Failed() bool {
+	return r.Code >= ErrorBase
+}
+
+// categories name the exit codes.
+var categories = map[int]string{
+	Success:                   "success",
+	SuccessBase:               "success",
+	SuccessUserAbort:          "user abort",
+	SuccessBootloaderUnlocked: "bootloader unlocked",
+	ErrorBase:                 "error",
+	ErrorPrereqs:              "prerequisites",
+	ErrorUserInput:            "user input",
+	ErrorUsbPerms:             "usb permissions",
+	ErrorAdb:                  "adb",
+	ErrorFastboot:             "fastboot",
+	ErrorRemote:               "download",
+	ErrorTWRP:                 "twrp",
+	ErrorTimeout:              "timeout",
+	ErrorCrash:                "crash",
+	ErrorNoDeviceConfig:       "no device config",
+	ErrorVerify:               "verification",
+}
+
+// ResultOf maps an error returned by the installer to how it ends. Errors
+// that aren't an *Error are counted as TWRP failures.
+func ResultOf(err error) Result {
+	code := Success
+	switch e := err.(type) {
+	case nil:
+	case *Error:
+		code = e.Code
+	default:
+		code = ErrorTWRP
+		if err == ErrBootloaderUnlocked {
+			code = SuccessBootloaderUnlocked
+		}
+	}
+	return Result{code, categories[code], err}
+}
+
+// ErrBootloaderUnlocked is returned by Run after unlocking the bootloader.
+// Unlocking factory resets the device, so the install has to be started again
+// once it has booted back up.
+var ErrBootloaderUnlocked = errors.New("bootloader unlocked")
@@ -0,0 +1,109 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package installer
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// flashExtraImages flashes the images in the device's extra zip when its
+// extra_mode is "images". Every image has to be in the zip before any of
+// them is flashed, so a bad zip never leaves the firmware half updated.
+func (in *install) flashExtraImages() error {
+	d := in.device
+	if d.Extra_file == "" || d.Extra_mode != extraModeImages {
+		return nil
+	}
+
+	dir, err := ioutil.TempDir("", "nh-extra")
+	if err != nil {
+		return newError("Failed to extract the extra zip", ErrorPrereqs, err)
+	}
+	defer os.RemoveAll(dir)
+
+	images, err := extractImages(d.Extra_file, d.Extra_images, dir)
+	if err != nil {
+		return newError("Failed to extract the extra zip", ErrorRemote, err)
+	}
+	return in.flashImages(images)
+}
+
+// extractImages extracts the images named in partitions, by the partition
+// they are for, from the zip at file into dir. It fails without extracting
+// anything if any of them is missing.
+func extractImages(file string, partitions map[string]string, dir string) ([]partitionImage, error) {
+	r, err := zip.OpenReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	entries := map[string]*zip.File{}
+	for _, f := range r.File {
+		entries[strings.TrimPrefix(f.Name, "./")] = f
+	}
+	var sorted, missing []string
+	for partition, name := range partitions {
+		sorted = append(sorted, partition)
+		if _, ok := entries[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("%s is missing %s", file, strings.Join(missing, ", "))
+	}
+
+	// flash in a stable order
+	sort.Strings(sorted)
+	var images []partitionImage
+	for _, partition := range sorted {
+		name := partitions[partition]
+		dst := filepath.Join(dir, partition+".img")
+		if err := extractFile(entries[name], dst); err != nil {
+			return nil, fmt.Errorf("extracting %s: %v", name, err)
+		}
+		images = append(images, partitionImage{partition: partition, name: partition + " image", file: dst})
+	}
+	return images, nil
+}
+
+// extractFile writes the contents of f to dst.
+func extractFile(f *zip.File, dst string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, rc); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,1266 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package installer installs Nethunter on a device connected over USB by
+// driving adb and fastboot. Anything that can answer its prompts can embed
+// it; the install command is a thin CLI around it.
+package installer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"../android"
+	"../remote"
+)
+
+const (
+	// How many times and how long to wait for fastboot and adb to agree on
+	// whether there is a device.
+	settleRetries = 2
+	settleDelay   = 1500 * time.Millisecond
+)
+
+// Options control how an install runs. Any callback left nil falls back to
+// a non-interactive default.
+type Options struct {
+	// Skip wiping data/system before installing (dirty upgrade)
+	NoWipe bool
+	// Update an existing Nethunter install, skipping the bootloader unlock
+	// and wiping
+	Update bool
+	// Only flash a device that is plugged into power
+	RequireCharging bool
+	// What happens to the data partition before installing: DataWipe (the
+	// default), DataFormat or DataKeep
+	DataMode string
+	// Flash even if the device doesn't match its config
+	Force bool
+	// Abort rather than warn when adb or fastboot are too old
+	Strict bool
+	// Times to retry the TWRP sequence after a lost connection
+	Retries int
+	// Remove downloaded files after a successful install
+	CleanDownloads bool
+	// Only flash TWRP, skipping the rest of the install
+	RecoveryOnly bool
+	// Local TWRP image to use instead of downloading the device's
+	TwrpImage string
+	// Slot to flash and boot on A/B devices, one of Slots. Empty is the
+	// active slot.
+	Slot string
+	// Leave the device in TWRP at the end instead of rebooting it
+	NoReboot bool
+	// Run wipes and installs in TWRP as OpenRecoveryScripts
+	ORS bool
+	// Skip the bootloader unlock check, the user vouches that it's unlocked
+	AssumeUnlocked bool
+	// Wait for the user before each partition is wiped, so they can abort
+	ConfirmWipes bool
+	// Stop once the bootloader is unlocked, before downloading anything
+	UnlockOnly bool
+	// Only run these steps from SelectableSteps, or all of them if empty
+	Steps []string
+	// Try mirrors fastest first instead of in the configured order
+	MirrorTest bool
+	// Check the Nethunter app and chroot are there once the device boots
+	VerifyInstall bool
+	// Download every file again, even if there is a copy from an earlier run
+	ForceDownload bool
+	// Directory of downloads shared between runs and installer locations,
+	// kept by their SHA-256 sum. Empty turns the shared cache off.
+	CacheDir string
+	// Never download, every file (and the manifest, if there is one) must
+	// already be in the working directory
+	Offline bool
+	// Google Apps variant to install, or "none". Asked for when empty.
+	Gapps string
+	// Never offer Google Apps, for builds that shouldn't ship them
+	HideGapps bool
+	// The user accepts the Google Apps terms, so they aren't asked to
+	AcceptTos bool
+	// Armored public key(s) release manifests are signed with
+	ManifestKey string
+	// Alternative URL prefixes to download from, by the prefix they replace
+	Mirrors map[string][]string
+	// Android API level of the system on the device before the install, or 0
+	// if unknown
+	InstalledSDK int
+	// Output isn't a terminal, so avoid progress that is redrawn in place
+	PlainProgress bool
+
+	// Echo prints a message for the user.
+	Echo func(format string, a ...interface{})
+	// WaitForUser blocks until the user has done what msg asks.
+	WaitForUser func(msg string)
+	// Confirm asks the user a yes/no question.
+	Confirm func(question string) (bool, error)
+	// Choose asks the user to pick one of choices, returning its index.
+	Choose func(question string, choices []string) (int, error)
+	// OnStep is called as the install moves on to each Step.
+	OnStep func(step string, percent float64)
+	// OnSummary is called with what was installed once an install succeeds.
+	OnSummary func(s Summary)
+}
+
+func (o *Options) setDefaults() {
+	if o.Echo == nil {
+		o.Echo = func(format string, a ...interface{}) {
+			fmt.Printf(format+"\n", a...)
+		}
+	}
+	if o.WaitForUser == nil {
+		o.WaitForUser = func(msg string) {}
+	}
+	if o.Confirm == nil {
+		o.Confirm = func(question string) (bool, error) { return true, nil }
+	}
+	if o.Choose == nil {
+		o.Choose = func(question string, choices []string) (int, error) { return 0, nil }
+	}
+	if o.OnStep == nil {
+		o.OnStep = func(step string, percent float64) {}
+	}
+	if o.OnSummary == nil {
+		o.OnSummary = func(s Summary) {}
+	}
+}
+
+// install holds the state of a single install.
+type install struct {
+	ctx      context.Context
+	opts     Options
+	device   Device
+	adb      android.AdbClient
+	fastboot android.FastbootClient
+
+	// SHA-256 of the files downloaded or already verified this run
+	sums map[string]string
+	// the device's release manifest, if it has one
+	manifest remote.Manifest
+	// where files are pushed on the device before installing them
+	pushDir string
+	// the chosen Google Apps variant, if any
+	gapps *GappsOption
+	// download speed of each mirror host in bytes per second, for MirrorTest
+	mirrorSpeeds map[string]float64
+	// whether anything has been flashed to the device yet
+	flashed bool
+	// whether TWRP is in the recovery partition, so it can be rebooted into
+	// instead of booting the image
+	twrpFlashed bool
+	// whether TWRP was seen booting in adb, so the user doesn't have to say
+	// when it is ready
+	twrpReady bool
+
+	// for the summary: when the install started, what has been installed and
+	// how much was downloaded
+	started    time.Time
+	components []string
+	downloaded int64
+}
+
+func newInstall(ctx context.Context, device Device, opts Options) *install {
+	opts.setDefaults()
+	in := &install{
+		ctx:      ctx,
+		opts:     opts,
+		device:   device,
+		adb:      android.NewAdbClient(),
+		fastboot: android.NewFastbootClient(),
+		pushDir:  "/sdcard",
+		sums:     make(map[string]string),
+		started:  time.Now(),
+
+		mirrorSpeeds: make(map[string]float64),
+	}
+	in.adb.NoProgress = opts.PlainProgress
+	in.adb.Ctx = ctx
+	in.fastboot.Ctx = ctx
+	return in
+}
+
+func (in *install) echo(format string, a ...interface{}) {
+	in.opts.Echo(format, a...)
+}
+
+// assets returns the files this install needs.
+func (in *install) assets() []Asset {
+	var assets []Asset
+	for _, a := range in.device.Assets() {
+		if in.opts.RecoveryOnly && a.File != in.device.Twrp_file {
+			continue
+		}
+		if in.isUnchosenGapps(a) {
+			continue
+		}
+		assets = append(assets, a)
+	}
+	return assets
+}
+
+// isUnchosenGapps reports whether a is a Google Apps variant other than the
+// one chosen.
+func (in *install) isUnchosenGapps(a Asset) bool {
+	for _, g := range in.device.Gapps {
+		if a.Name == g.assetName() {
+			return in.gapps == nil || in.gapps.Name != g.Name
+		}
+	}
+	return false
+}
+
+// chooseGapps picks the Google Apps variant to install, asking the user
+// unless one was given in the options.
+func (in *install) chooseGapps() error {
+	const none = "none"
+	if len(in.device.Gapps) == 0 || in.opts.RecoveryOnly || in.device.bootOnly() || in.opts.Gapps == none {
+		return nil
+	}
+	if in.opts.HideGapps {
+		if in.opts.Gapps != "" {
+			return newError("Google Apps aren't offered by this installer", ErrorUserInput, nil)
+		}
+		return nil
+	}
+	defer in.acceptGappsTerms()
+
+	if in.opts.Gapps != "" {
+		g, ok := in.device.gappsOption(in.opts.Gapps)
+		if !ok {
+			var names []string
+			for _, g := range in.device.Gapps {
+				names = append(names, g.Name)
+			}
+			return newError(fmt.Sprintf("Unknown Google Apps variant %q, choose one of %s or %s", in.opts.Gapps, strings.Join(names, ", "), none), ErrorUserInput, nil)
+		}
+		in.gapps = &g
+		return nil
+	}
+
+	var choices []string
+	for _, g := range in.device.Gapps {
+		choices = append(choices, g.Name)
+	}
+	choices = append(choices, none)
+	i, err := in.opts.Choose("Which Google Apps would you like to install?", choices)
+	if err != nil {
+		return newError("Failed to read input", ErrorUserInput, err)
+	}
+	if i < len(in.device.Gapps) {
+		in.gapps = &in.device.Gapps[i]
+	}
+	return nil
+}
+
+// gappsTermsRecord remembers when the user accepted the Google Apps terms,
+// so they are only asked once.
+const gappsTermsRecord = ".gapps-terms-accepted"
+
+// acceptGappsTerms has the user acknowledge the Google Apps terms once
+// Google Apps have been chosen, dropping them if the user doesn't accept.
+// The acceptance is recorded so later runs don't ask again.
+func (in *install) acceptGappsTerms() {
+	if in.gapps == nil {
+		return
+	}
+	if b, err := ioutil.ReadFile(gappsTermsRecord); err == nil {
+		in.echo("Google Apps terms were accepted %s", strings.TrimSpace(string(b)))
+		return
+	}
+
+	how := "with --accept-tos"
+	if !in.opts.AcceptTos {
+		in.echo(MsgGappsTerms)
+		accept, err := in.opts.Confirm("Do you accept the Google Apps terms?")
+		if err != nil || !accept {
+			in.echo("Skipping Google Apps")
+			in.gapps = nil
+			return
+		}
+		how = "at the prompt"
+	}
+	record := fmt.Sprintf("on %s %s", time.Now().Format(time.RFC3339), how)
+	in.echo("Google Apps terms accepted " + how)
+	if err := ioutil.WriteFile(gappsTermsRecord, []byte(record+"\n"), 0644); err != nil {
+		in.echo("Warning: failed to record accepting the Google Apps terms: " + err.Error())
+	}
+}
+
+// verifyGapps checks the chosen Google Apps against the sum in the config.
+func (in *install) verifyGapps() error {
+	if in.gapps == nil || in.gapps.Sha256 == "" {
+		return nil
+	}
+
+	g := in.gapps
+	var err error
+	if sum, ok := in.sums[g.File]; ok {
+		if !strings.EqualFold(sum, g.Sha256) {
+			err = fmt.Errorf("%s is corrupt (sha256 %s, expected %s)", g.File, sum, g.Sha256)
+		}
+	} else {
+		err = remote.VerifyFile(g.File, strings.ToLower(g.Sha256))
+	}
+	if err != nil {
+		in.echo("Delete the file and re-run the installer to download it again.")
+		return newError("Failed to verify Google Apps", ErrorRemote, err)
+	}
+	return nil
+}
+
+// unlockSettleTime is how long a device gets to leave the bootloader after
+// being rebooted to do its post-unlock factory reset.
+const unlockSettleTime = 45 * time.Second
+
+// rebootAfterUnlock reboots the device so it can factory reset after being
+// unlocked. Some devices come straight back to the bootloader instead, so
+// they are rebooted once more before asking the user to do it.
+func (in *install) rebootAfterUnlock() {
+	for attempt := 0; attempt < 2; attempt++ {
+		in.fastboot.Reboot()
+		err := in.fastboot.WaitUntil(android.DeviceReappeared, unlockSettleTime)
+		if err == android.ErrWaitTimeout {
+			status, _ := in.fastboot.Status()
+			if status == android.NoDeviceFound {
+				return
+			}
+			in.echo("Your device is still in the bootloader after rebooting, trying again...")
+			continue
+		} else if err != nil {
+			return
+		}
+		in.echo("Your device came back to the bootloader instead of booting, rebooting it again...")
+	}
+	in.echo(MsgStuckAfterUnlock)
+}
+
+// workRoot holds a work directory per device for partial downloads.
+const workRoot = ".installer-work"
+
+// useWorkDir keeps partial downloads in a directory of the device's own, so
+// installs on several devices from the same directory don't clobber each
+// other while still resuming downloads for the same device.
+func (in *install) useWorkDir() error {
+	serial := "unknown"
+	if id, err := in.fastboot.GetIdentity(); err == nil && id.Serialno != "" {
+		// serials are reported by the device, keep them to a safe name
+		serial = strings.Map(func(r rune) rune {
+			if r == '-' || r == '_' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return r
+			}
+			return '_'
+		}, id.Serialno)
+	}
+	dir := filepath.Join(workRoot, serial)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	remote.WorkDir = dir
+	return nil
+}
+
+// cleanWorkDir removes the work directory once nothing is left in it to
+// resume.
+func (in *install) cleanWorkDir() {
+	if remote.WorkDir == "" {
+		return
+	}
+	if os.Remove(remote.WorkDir) == nil {
+		os.Remove(workRoot)
+	}
+}
+
+// selected reports whether step was picked to run with Options.Steps.
+func (in *install) selected(step string) bool {
+	if len(in.opts.Steps) == 0 {
+		return true
+	}
+	for _, s := range in.opts.Steps {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
+// skipStep notes that step isn't run because it wasn't picked.
+func (in *install) skipStep(step string) {
+	in.echo("Skipping the %s step (not selected with --steps)", step)
+}
+
+// ctxError returns why the install was stopped early, if it was.
+func (in *install) ctxError() error {
+	switch err := in.ctx.Err(); err {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return newError("Install timed out", ErrorTimeout, err)
+	default:
+		return newError("Install cancelled", ErrorUserInput, err)
+	}
+}
+
+// step reports that the install has moved on to step, stopping the install
+// if it has been cancelled.
+func (in *install) step(step string) error {
+	if err := in.ctxError(); err != nil {
+		return err
+	}
+	in.opts.OnStep(step, stepPercent(step))
+	return nil
+}
+
+// rewind offers to reboot the device out of the bootloader after the install
+// is cancelled before anything was flashed, so it isn't left stranded on the
+// bootloader screen. It is best effort since the device may be in any state.
+func (in *install) rewind() {
+	if in.flashed || in.ctx.Err() != context.Canceled {
+		return
+	}
+
+	// the install's own clients were cancelled along with it
+	fastboot := android.NewFastbootClient()
+	if status, err := fastboot.Status(); err != nil || status != android.DeviceConnected {
+		return
+	}
+
+	in.echo(MsgAborted)
+	reboot, err := in.opts.Confirm("Reboot your device back to Android?")
+	if err != nil || !reboot {
+		in.echo("Leaving your device in the bootloader")
+		return
+	}
+	if err := fastboot.Reboot(); err != nil {
+		in.echo("Warning: failed to reboot your device: " + err.Error())
+		return
+	}
+	in.echo("Rebooting your device back to Android")
+}
+
+// sleep pauses the install for d, or until it is cancelled.
+func (in *install) sleep(d time.Duration) {
+	select {
+	case <-in.ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+func (in *install) verifyAdbStatus() error {
+	status, err := in.adb.Status()
+	for i := 0; i < settleRetries && err == nil && adbStuck(status); i++ {
+		in.sleep(settleDelay)
+		status, err = in.adb.Status()
+	}
+	if err == nil && adbStuck(status) && in.restartAdbServer() {
+		status, err = in.adb.Status()
+	}
+	if err != nil {
+		return newError("Failed to get adb status", ErrorAdb, err)
+	}
+	if status == android.NoDeviceFound || status == android.DeviceUnauthorized || status == android.DeviceOffline {
+		return newError(MsgAdbIssue, ErrorAdb, nil)
+	} else if status == android.NoUsbPerms {
+		return newError(MsgFixPerms, ErrorUsbPerms, nil)
+	}
+	return nil
+}
+
+// adbStuck reports whether status is one a stuck adb server keeps reporting
+// even after the device is replugged.
+func adbStuck(status android.AndroidDeviceStatus) bool {
+	return status == android.NoDeviceFound || status == android.DeviceOffline
+}
+
+// adbRestartWait is how long to wait for the device to show up again after
+// restarting the adb server.
+const adbRestartWait = 15 * time.Second
+
+// restartAdbServer restarts the adb server and waits for it to see the
+// device, reporting whether it does.
+func (in *install) restartAdbServer() bool {
+	in.echo("adb isn't seeing a usable device, restarting the adb server...")
+	if err := in.adb.KillServer(); err != nil {
+		in.echo("Warning: failed to stop the adb server: " + err.Error())
+	}
+	if err := in.adb.StartServer(); err != nil {
+		in.echo("Warning: failed to start the adb server: " + err.Error())
+		return false
+	}
+	return in.adb.WaitUntil(android.DevicePresent, adbRestartWait) == nil
+}
+
+func (in *install) verifyFastbootStatus() error {
+	status, err := in.fastboot.Status()
+	if err != nil {
+		return newError("Failed to get fastboot status", ErrorFastboot, err)
+	}
+	if status == android.NoDeviceFound {
+		return newError(MsgFastbootNoDeviceFound, ErrorFastboot, nil)
+	} else if status == android.NoUsbPerms {
+		return newError(MsgFixPerms, ErrorUsbPerms, nil)
+	}
+	return nil
+}
+
+// inBootloader works out whether the device is in the bootloader rather than
+// booted into Android or recovery. While the device is re-enumerating both
+// tools can briefly report it, so both are queried again after a short settle
+// until only one does. fastboot is preferred if they keep disagreeing since it
+// only ever sees a device that really is in the bootloader, whereas the adb
+// server can hold on to stale devices.
+func (in *install) inBootloader() bool {
+	for i := 0; ; i++ {
+		fastbootStatus, _ := in.fastboot.Status()
+		adbStatus, _ := in.adb.Status()
+		if fastbootStatus == android.NoDeviceFound || adbStatus == android.NoDeviceFound {
+			return fastbootStatus != android.NoDeviceFound
+		}
+
+		in.echo("Both fastboot (%s) and adb (%s) report a device", fastbootStatus, adbStatus)
+		if i == settleRetries {
+			in.echo("Assuming your device is in the bootloader")
+			return true
+		}
+		in.sleep(settleDelay)
+	}
+}
+
+// checkURLs makes sure every asset can still be downloaded, listing the ones
+// that can't.
+func (in *install) checkURLs(assets []Asset) (reachable []Asset, err error) {
+	if len(assets) == 0 {
+		return nil, nil
+	}
+
+	in.echo("Checking download links...")
+	failed, clock := false, false
+	for _, a := range assets {
+		var err error
+		for _, dlLink := range in.mirrorURLs(a.URL) {
+			if err = remote.CheckURL(in.ctx, dlLink); err == nil {
+				break
+			}
+		}
+		if err == nil {
+			reachable = append(reachable, a)
+		} else if a.Optional {
+			in.dropOptional(a, err)
+		} else {
+			in.echo("    - %s: %s", a.Name, err.Error())
+			failed = true
+			clock = clock || remote.IsClockError(err)
+		}
+	}
+	if clock {
+		return nil, newError(MsgClockWrong, ErrorRemote, nil)
+	}
+	if failed {
+		return nil, newError(MsgDeadURLs, ErrorRemote, nil)
+	}
+	return reachable, nil
+}
+
+// dropOptional carries on with the install without the optional asset a,
+// which can't be downloaded.
+func (in *install) dropOptional(a Asset, err error) {
+	in.echo("Warning: skipping the optional %s, it can't be downloaded: %s", a.Name, err.Error())
+	if a.File == in.device.Extra_file {
+		in.device.Extra_file = ""
+	}
+	if in.gapps != nil && a.File == in.gapps.File {
+		in.gapps = nil
+	}
+}
+
+// remoteError is newError for a failed download, explaining certificate
+// errors caused by a wrong clock.
+func remoteError(msg string, err error) error {
+	if remote.IsClockError(err) {
+		return newError(MsgClockWrong, ErrorRemote, err)
+	}
+	return newError(msg, ErrorRemote, err)
+}
+
+// printInstallPlan describes what is about to be installed and which files
+// still need downloading.
+func (in *install) printInstallPlan() {
+	in.echo("\nThe following will be installed on your %s:", in.device.Common_name)
+	for _, a := range in.assets() {
+		state := "already downloaded"
+		if _, err := os.Stat(a.File); os.IsNotExist(err) {
+			state = "will be downloaded"
+		}
+		in.echo("    - %-20s %s (%s)", a.Name+":", a.File, state)
+	}
+	in.echo("")
+}
+
+// printManualActions lists what the user will have to do on the device or at
+// the keyboard during this install, so no step comes as a surprise.
+func (in *install) printManualActions() {
+	d := in.device
+	var actions []string
+	if !in.opts.Update && !in.opts.AssumeUnlocked && in.selected(StepUnlock) {
+		if unlocked, err := in.fastboot.Unlocked(); err != nil || !unlocked {
+			actions = append(actions, "Confirm the bootloader unlock on your device, which wipes it. Then set up Android, re-enable USB debugging and run the installer again")
+		}
+	}
+	if in.requiresCharging() {
+		actions = append(actions, "Keep your device plugged into power")
+	}
+
+	base := in.selected(StepWipe) || in.selected(StepPush) || in.selected(StepInstall)
+	filesystem := in.selected(StepInstallFilesystem)
+	if !in.opts.UnlockOnly && !d.bootOnly() && !in.opts.RecoveryOnly && (base || filesystem) {
+		actions = append(actions, "Swipe to allow system modifications each time TWRP starts, and press enter here if asked to")
+		wipes := in.selected(StepWipe) && !in.opts.Update && !in.opts.NoWipe
+		if in.opts.ConfirmWipes && wipes {
+			actions = append(actions, "Press enter before each partition is wiped")
+		}
+		if in.formatsData() && wipes {
+			actions = append(actions, "Confirm formatting data, which erases internal storage")
+		}
+		if base && filesystem {
+			actions = append(actions, "Re-enable USB debugging once NethunterOS has booted, then press enter here")
+		}
+	}
+	if len(actions) == 0 {
+		return
+	}
+
+	in.echo("You will be asked to:")
+	for _, a := range actions {
+		in.echo("    - %s", a)
+	}
+	in.echo("")
+}
+
+// fetchManifest fetches the device's signed release manifest so downloads
+// can be checked as they finish.
+func (in *install) fetchManifest() error {
+	if in.opts.ManifestKey == "" {
+		return newError("Failed to verify downloads", ErrorRemote, fmt.Errorf("no manifest_key configured"))
+	}
+	keyring, err := readKeyring(in.opts.ManifestKey)
+	if err != nil {
+		return newError("Failed to read manifest key", ErrorRemote, err)
+	}
+
+	if in.opts.Offline {
+		in.manifest, err = remote.ReadManifest(path.Base(in.device.Manifest_url), keyring)
+	} else {
+		in.manifest, err = remote.FetchManifest(in.ctx, in.device.Manifest_url, keyring)
+	}
+	if err != nil {
+		return remoteError("Failed to fetch manifest", err)
+	}
+	return nil
+}
+
+// verifyManifest checks the device's assets against its signed release
+// manifest.
+func (in *install) verifyManifest() error {
+	in.echo("Verifying downloads...")
+	unlisted, err := verifyManifest(in.manifest, in.assets(), in.sums)
+	if err != nil {
+		in.echo("Delete the file and re-run the installer to download it again.")
+		return newError("Failed to verify downloads", ErrorRemote, err)
+	}
+	for _, a := range unlisted {
+		in.echo("Warning: %s is not listed in the manifest and can't be verified", a.File)
+	}
+	return nil
+}
+
+// checkDevice re-identifies the connected device and makes sure it is the one
+// the config was written for. This is the last check before anything is
+// flashed, so it also catches the device being swapped during downloads.
+func (in *install) checkDevice() error {
+	d := in.device
+	id, err := in.fastboot.GetIdentity()
+	if err != nil {
+		return newError("Failed to get device product info", ErrorFastboot, err)
+	}
+
+	in.echo("Device reports product %q (variant %q), config expects %q (variant %q)",
+		id.Product, id.Variant, d.Product_name, d.Variant)
+	if deviceMatchesConfig(id, d) {
+		return nil
+	}
+
+	if in.opts.Force {
+		in.echo("Warning: device doesn't match its config, flashing anyway (--force)")
+		return nil
+	}
+	return newError(MsgDeviceMismatch, ErrorFastboot, nil)
+}
+
+// checkBootloaderVersion makes sure the device's bootloader is at least the
+// config's Min_bootloader, since some ROMs bootloop on older firmware.
+func (in *install) checkBootloaderVersion() error {
+	min := in.device.Min_bootloader
+	if min == "" {
+		return nil
+	}
+
+	version, err := in.fastboot.GetBootloaderVersion()
+	if err != nil || version == "" {
+		in.echo("Warning: unable to determine bootloader version, make sure it is at least %s", min)
+		return nil
+	}
+
+	in.echo("Bootloader version %s (need at least %s)", version, min)
+	if compareVersions(version, min) < 0 {
+		return newError(fmt.Sprintf(MsgBootloaderTooOld, version, min), ErrorFastboot, nil)
+	}
+	return nil
+}
+
+// checkAndroidVersion warns when data is kept over a system built on a
+// different enough Android version that the new one is likely to bootloop.
+func (in *install) checkAndroidVersion() {
+	installed, target := in.opts.InstalledSDK, in.device.Android_sdk
+	if installed == 0 || target == 0 || !(in.opts.Update || in.opts.NoWipe) {
+		return
+	}
+	if installed-target <= 1 && target-installed <= 1 {
+		return
+	}
+	in.echo(MsgAndroidVersionMismatch, androidVersion(installed), installed, androidVersion(target), target)
+}
+
+// minToolsVersion is the oldest platform-tools release known to work with
+// fastbootd and current TWRP builds.
+const minToolsVersion = "30.0.0"
+
+// checkToolVersions warns, or fails in strict mode, when the bundled adb or
+// fastboot predate minToolsVersion.
+func (in *install) checkToolVersions() error {
+	adbVersion, _ := in.adb.Version()
+	fastbootVersion, _ := in.fastboot.Version()
+	in.echo("Found adb %s and fastboot %s", orUnknown(adbVersion), orUnknown(fastbootVersion))
+
+	tools := []struct{ name, version string }{
+		{"adb", adbVersion},
+		{"fastboot", fastbootVersion},
+	}
+	for _, t := range tools {
+		if t.version != "" && compareVersions(t.version, minToolsVersion) >= 0 {
+			continue
+		}
+		msg := fmt.Sprintf(MsgOldTools, t.name, orUnknown(t.version), minToolsVersion)
+		if in.opts.Strict {
+			return newError(msg, ErrorPrereqs, nil)
+		}
+		in.echo("Warning: " + msg)
+	}
+	return nil
+}
+
+func orUnknown(version string) string {
+	if version == "" {
+		return "(unknown version)"
+	}
+	return version
+}
+
+// nethunterVersionProp is set by NethunterOS builds to their version.
+const nethunterVersionProp = "ro.nethunter.version"
+
+// InstalledVersion returns the version of Nethunter running on the connected
+// device, or "" if the device isn't booted into Nethunter. Call it before
+// Identify, which reboots the device into the bootloader.
+func InstalledVersion(ctx context.Context, opts Options) string {
+	in := newInstall(ctx, Device{}, opts)
+	if status, err := in.adb.Status(); err != nil || status != android.DeviceConnected {
+		return ""
+	}
+	version, err := in.adb.GetProp(nethunterVersionProp)
+	if err != nil {
+		return ""
+	}
+	return version
+}
+
+// InstalledSDK returns the Android API level of the system running on the
+// connected device, or 0 if the device isn't booted into Android.
+func InstalledSDK(ctx context.Context, opts Options) int {
+	in := newInstall(ctx, Device{}, opts)
+	if status, err := in.adb.Status(); err != nil || status != android.DeviceConnected {
+		return 0
+	}
+	sdk, err := in.adb.GetProp("ro.build.version.sdk")
+	if err != nil {
+		return 0
+	}
+	level, _ := strconv.Atoi(sdk)
+	return level
+}
+
+// Identify gets the connected device into the bootloader and finds its config
+// in nhDevices.
+func Identify(ctx context.Context, nhDevices Devices, opts Options) (Device, error) {
+	in := newInstall(ctx, Device{}, opts)
+	d, err := in.identify(nhDevices)
+	if err != nil {
+		if cerr := in.ctxError(); cerr != nil {
+			in.rewind()
+			return Device{}, cerr
+		}
+	}
+	return d, err
+}
+
+func (in *install) identify(nhDevices Devices) (Device, error) {
+	in.echo("")
+	in.echo("Verifying installer tools...")
+	if _, err := in.adb.Status(); err != nil {
+		in.echo(MsgIncompleteZip)
+		return Device{}, newError("Failed to run adb", ErrorPrereqs, err)
+	}
+	if _, err := in.fastboot.Status(); err != nil {
+		in.echo(MsgIncompleteZip)
+		return Device{}, newError("Failed to run fastboot", ErrorPrereqs, err)
+	}
+	if err := in.checkToolVersions(); err != nil {
+		return Device{}, err
+	}
+
+	in.echo("Checking USB permissions...")
+	if !in.inBootloader() {
+		// We are in ADB mode (normal boot or recovery).
+
+		if err := in.verifyAdbStatus(); err != nil {
+			return Device{}, err
+		}
+
+		in.echo("Rebooting your device into bootloader...")
+		if err := in.adb.Reboot("bootloader"); err != nil {
+			return Device{}, newError("Failed to reboot into bootloader", ErrorAdb, err)
+		}
+
+		if err := in.fastboot.WaitUntil(android.DevicePresent, rebootTimeout); err != nil {
+			return Device{}, newError("Failed to reboot device into bootloader!", ErrorAdb, nil)
+		}
+	}
+
+	// We are in fastboot mode (the bootloader).
+
+	if err := in.verifyFastbootStatus(); err != nil {
+		return Device{}, err
+	}
+
+	if err := in.step(StepIdentify); err != nil {
+		return Device{}, err
+	}
+	in.echo("Identifying your device...")
+	identity, err := in.fastboot.GetIdentity()
+	if err != nil {
+		return Device{}, newError("Failed to get device product info", ErrorFastboot, err)
+	}
+	currDevice, err := FindDeviceConfig(nhDevices, identity)
+
+	// Some devices share a product name (e.g. every OnePlus reports
+	// QC_Reference_Phone). Unless a config matches on the variant, their
+	// hints or the user have to pick one
+	if err == ErrNoDeviceConfig {
+		if candidates := sharedProductCandidates(nhDevices, identity); len(candidates) > 0 {
+			currDevice, err = in.pickSharedDevice(identity.Product, candidates)
+			if err != nil {
+				return Device{}, err
+			}
+		}
+	}
+
+	// Check that we have the device config in the file
+	if err != nil {
+		return Device{}, newError(fmt.Sprintf("Device config not found for product %q! Bye.", identity.Product), ErrorNoDeviceConfig, nil)
+	}
+	in.echo("Device and config found, using %s (%s) configuration and endpoints", currDevice.Common_name, currDevice.Product_name)
+	for _, o := range currDevice.overrideURLs() {
+		in.echo("Warning: using %s from the environment instead of the shipped config", o)
+	}
+
+	in.device = currDevice
+	if err := in.checkBootloaderVersion(); err != nil {
+		return Device{}, err
+	}
+	return currDevice, nil
+}
+
+// pickSharedDevice picks which of candidates, that all report product, is
+// connected. A single candidate whose hint variable matches is picked
+// without asking, otherwise the user chooses.
+func (in *install) pickSharedDevice(product string, candidates []Device) (Device, error) {
+	vars := map[string]string{}
+	var hinted []Device
+	for _, d := range candidates {
+		if d.Hint_var == "" {
+			continue
+		}
+		value, ok := vars[d.Hint_var]
+		if !ok {
+			value, _ = in.fastboot.GetVar(d.Hint_var)
+			vars[d.Hint_var] = value
+		}
+		if value != "" && value == d.Hint {
+			hinted = append(hinted, d)
+		}
+	}
+	if len(hinted) == 1 {
+		in.echo("Detected %s from %s %q", hinted[0].Common_name, hinted[0].Hint_var, hinted[0].Hint)
+		return hinted[0], nil
+	} else if len(hinted) > 1 {
+		candidates = hinted
+	}
+
+	var choices []string
+	for _, d := range candidates {
+		choices = append(choices, d.Common_name)
+	}
+	i, err := in.opts.Choose(fmt.Sprintf("Several devices report %q. Select which device: ", product), choices)
+	if err != nil {
+		return Device{}, newError("Failed to read input", ErrorUserInput, err)
+	}
+	return candidates[i], nil
+}
+
+// Run installs Nethunter on the connected device using its config d.
+func Run(ctx context.Context, d Device, opts Options) error {
+	in := newInstall(ctx, d, opts)
+	err := in.run()
+	if err != nil {
+		// commands killed by the context fail with unhelpful errors
+		if cerr := in.ctxError(); cerr != nil {
+			in.rewind()
+			return cerr
+		}
+	}
+	return err
+}
+
+func (in *install) run() error {
+	if in.opts.RecoveryOnly && in.device.bootOnly() {
+		return newError("The "+in.device.Common_name+" is installed without TWRP, there is no recovery to flash", ErrorUserInput, nil)
+	}
+	if in.opts.RecoveryOnly && !in.device.flashesTwrp() {
+		return newError("TWRP is never flashed on the "+in.device.Common_name+", only booted", ErrorUserInput, nil)
+	}
+	if in.opts.TwrpImage != "" {
+		if err := in.useTwrpImage(in.opts.TwrpImage); err != nil {
+			return err
+		}
+	}
+	// nothing is downloaded when only unlocking
+	if !in.opts.UnlockOnly {
+		if err := in.chooseGapps(); err != nil {
+			return err
+		}
+		in.printInstallPlan()
+		in.checkAndroidVersion()
+	}
+	in.printManualActions()
+
+	if in.opts.AssumeUnlocked {
+		in.echo(MsgAssumeUnlocked)
+	} else if !in.opts.Update && in.selected(StepUnlock) {
+		in.opts.WaitForUser("Press enter to continue with bootloader unlock check. Unlocking will wipe device if first time and will require restart.") // not sure about the sentence here
+	}
+
+	if err := in.step(StepUnlock); err != nil {
+		return err
+	}
+	if !in.selected(StepUnlock) {
+		in.skipStep(StepUnlock)
+	}
+	// Nethunter is already installed, so the bootloader must be unlocked
+	unlocked := in.opts.Update || in.opts.AssumeUnlocked || !in.selected(StepUnlock)
+	var err error
+	if !unlocked {
+		unlocked, err = in.fastboot.Unlocked()
+		if err != nil {
+			in.echo("Warning: unable to determine bootloader lock state: " + err.Error())
+		}
+	}
+
+	if !unlocked {
+		in.echo("Unlocking bootloader, you will need to confirm this on your device...")
+		if err = in.fastboot.Unlock(); err != nil {
+			return newError("Failed to unlock bootloader", ErrorFastboot, err)
+		}
+		in.rebootAfterUnlock()
+		in.echo(MsgUnlockSuccess)
+		if in.opts.UnlockOnly {
+			in.echo(MsgUnlockOnly)
+		}
+		return ErrBootloaderUnlocked
+	}
+	if in.opts.UnlockOnly {
+		in.echo("Your bootloader is already unlocked, re-run the installer without --unlock-only to install Nethunter.")
+		return ErrBootloaderUnlocked
+	}
+
+	if err := in.step(StepDownload); err != nil {
+		return err
+	}
+	// without the download step everything has to be here already
+	noDownload := in.opts.Offline || !in.selected(StepDownload)
+	if err := in.useWorkDir(); err != nil {
+		in.echo("Warning: failed to create a work directory for this device, keeping partial downloads next to the files: " + err.Error())
+	}
+	defer in.cleanWorkDir()
+	if !in.selected(StepDownload) {
+		in.skipStep(StepDownload)
+	}
+
+	// Make sure everything we still need to download is reachable before we
+	// start wiping the device
+	var missing, present, cached []Asset
+	for _, a := range in.assets() {
+		if _, err := os.Stat(a.File); os.IsNotExist(err) || in.opts.ForceDownload {
+			missing = append(missing, a)
+		} else if err == nil {
+			present = append(present, a)
+			if remote.HasValidators(a.File) {
+				cached = append(cached, a)
+			}
+		}
+	}
+	if noDownload && len(missing) > 0 {
+		for _, a := range missing {
+			in.echo("    - %s: %s", a.Name, a.File)
+		}
+		if in.opts.Offline {
+			return newError("Files needed for the install are missing from the offline directory", ErrorRemote, nil)
+		}
+		return newError("Files needed for the install haven't been downloaded", ErrorRemote, nil)
+	}
+
+	if in.device.Manifest_url != "" {
+		if err := in.fetchManifest(); err != nil {
+			return err
+		}
+	}
+	if !noDownload {
+		missing = in.fromCache(missing)
+	}
+	missing, err = in.checkURLs(missing)
+	if err != nil {
+		return err
+	}
+
+	// Files from an earlier run are downloaded again if they changed since
+	if !noDownload {
+		for _, a := range cached {
+			if err := in.refresh(a); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Check the files we already have up front so only corrupt ones are
+	// downloaded again
+	if bad, errs := in.verifyCached(present); len(bad) > 0 {
+		in.echo("These files from an earlier run are corrupt:")
+		for _, err := range errs {
+			in.echo("    - %s", err.Error())
+		}
+		if noDownload {
+			return newError("Failed to verify the files from an earlier run", ErrorRemote, nil)
+		}
+		in.echo("They will be downloaded again.")
+		for _, a := range bad {
+			remote.Discard(a.File)
+		}
+		missing = append(missing, bad...)
+	}
+	if !noDownload {
+		if err := in.checkDiskSpace(missing); err != nil {
+			return err
+		}
+	}
+
+	// Extras (firmware/baseband), NethunterOS, the Nethunter generic
+	// filesystem, gapps and TWRP
+	for _, a := range missing {
+		if in.opts.ForceDownload {
+			remote.Discard(a.File)
+		}
+		if err := in.download(a); err != nil && a.Optional && in.ctx.Err() == nil {
+			in.dropOptional(a, err)
+			continue
+		} else if err != nil {
+			return remoteError("Failed to download "+a.Name, err)
+		}
+		if info, err := os.Stat(a.File); err == nil {
+			in.downloaded += info.Size()
+		}
+		if err := recordDownload(a.File); err != nil {
+			in.echo("Warning: failed to record download: " + err.Error())
+		}
+		in.toCache(a)
+	}
+
+	// Check everything against the release manifest, if there is one
+	if in.device.Manifest_url != "" {
+		if err := in.verifyManifest(); err != nil {
+			return err
+		}
+	}
+
+	if err := in.verifyGapps(); err != nil {
+		return err
+	}
+	for _, a := range in.assets() {
+		if err := checkSize(a); err != nil {
+			in.echo("Delete the file and re-run the installer to download it again.")
+			return newError("Failed to verify "+a.Name, ErrorRemote, err)
+		}
+	}
+
+	if in.device.bootOnly() {
+		return in.runBootOnly()
+	}
+
+	in.opts.WaitForUser("Press enter to start the installation")
+
+	// Flash TWRP recovery
+	if err := in.step(StepFlashTwrp); err != nil {
+		return err
+	}
+	if err := in.checkDevice(); err != nil {
+		return err
+	}
+	if err := in.useSlot(); err != nil {
+		return err
+	}
+	if !in.selected(StepFlashTwrp) {
+		in.skipStep(StepFlashTwrp)
+	} else if in.device.flashesTwrp() {
+		in.echo("Starting TWRP flash")
+		if err := in.flashTwrp(); err != nil {
+			return err
+		}
+		in.installed("TWRP")
+	} else {
+		in.echo("Skipping TWRP flash, it is only booted on the %s", in.device.Common_name)
+	}
+
+	if in.opts.RecoveryOnly {
+		if err := in.step(StepDone); err != nil {
+			return err
+		}
+		in.echo(MsgRecoveryFlashed)
+		in.opts.OnSummary(in.summary())
+		return nil
+	}
+
+	if in.selected(StepInstall) {
+		if err := in.flashExtraImages(); err != nil {
+			return err
+		}
+		if err := in.flashPartitionImages(); err != nil {
+			return err
+		}
+	}
+
+	base := in.selected(StepWipe) || in.selected(StepPush) || in.selected(StepInstall)
+	filesystem := in.selected(StepInstallFilesystem)
+	if base {
+		if err := in.retryTransient(in.installBase); err != nil {
+			return err
+		}
+
+		in.echo(MsgSuccess)
+		// the filesystem install needs the OS booted once, so only a base
+		// install can be left in TWRP
+		if !filesystem && in.opts.NoReboot {
+			in.echo(MsgNoReboot)
+		} else if err = in.adb.Reboot(""); err != nil {
+			in.echo("\nPlease reboot your device manually by going to Reboot > System > Do Not Install")
+			return newError("Failed to reboot", ErrorAdb, err)
+		}
+	}
+
+	if !filesystem {
+		in.skipStep(StepInstallFilesystem)
+	} else {
+		// the filesystem needs the OS booted once, which the base install
+		// just did, otherwise the device is still in the bootloader
+		if base {
+			// Wait for user to select install form usb option
+			in.echo(MsgReenable)
+			in.opts.WaitForUser("Press enter when ADB is reenabled")
+
+			if err := in.verifyAdbStatus(); err != nil {
+				return err
+			}
+
+			in.echo("Rebooting your device into bootloader...")
+			if err = in.adb.Reboot("bootloader"); err != nil {
+				return newError("Failed to reboot into bootloader", ErrorAdb, err)
+			}
+			if err = in.fastboot.WaitUntil(android.DevicePresent, rebootTimeout); err != nil {
+				return newError("Failed to reboot device into bootloader!", ErrorAdb, nil)
+			}
+		}
+
+		if err := in.retryTransient(in.installFilesystem); err != nil {
+			return err
+		}
+
+		if err := in.waitForTwrpAdb(); err != nil {
+			return err
+		}
+	}
+
+	if err := in.step(StepDone); err != nil {
+		return err
+	}
+	in.echo(MsgFinished)
+	if !filesystem {
+		// already rebooted after the base install, or still in the bootloader
+		if !base && !in.opts.NoReboot {
+			in.fastboot.Reboot()
+		}
+	} else if in.opts.NoReboot {
+		in.echo(MsgNoReboot)
+	} else if err = in.adb.Reboot(""); err != nil {
+		in.echo("\nPlease reboot your device manually by going to Reboot > System > Do Not Install")
+		return newError("Failed to reboot", ErrorAdb, err)
+	} else if in.opts.VerifyInstall {
+		in.verifyInstall()
+	}
+
+	if in.opts.CleanDownloads {
+		reclaimed, err := cleanDownloads()
+		if err != nil {
+			in.echo("Warning: failed to remove downloaded files: " + err.Error())
+		}
+		in.echo("Removed downloaded files, reclaiming %s", formatBytes(reclaimed))
+	}
+	in.opts.OnSummary(in.summary())
+	return nil
+}
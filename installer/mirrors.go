@@ -0,0 +1,158 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package installer
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"../remote"
+)
+
+// mirrorURLs returns dlLink followed by the same file on every mirror
+// configured for its prefix.
+func (in *install) mirrorURLs(dlLink string) []string {
+	var prefixes []string
+	for prefix := range in.opts.Mirrors {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	urls := []string{dlLink}
+	for _, prefix := range prefixes {
+		if !strings.HasPrefix(dlLink, prefix) {
+			continue
+		}
+		for _, m := range in.opts.Mirrors[prefix] {
+			urls = append(urls, m+strings.TrimPrefix(dlLink, prefix))
+		}
+	}
+	if in.opts.MirrorTest && len(urls) > 1 {
+		in.sortBySpeed(urls)
+	}
+	return urls
+}
+
+// sortBySpeed puts the fastest of urls first, measuring each server the
+// first time it comes up and remembering it for the rest of the run. Servers
+// that fail the test go last.
+func (in *install) sortBySpeed(urls []string) {
+	for _, dlLink := range urls {
+		host := hostOf(dlLink)
+		if _, ok := in.mirrorSpeeds[host]; ok {
+			continue
+		}
+		speed, err := remote.MeasureSpeed(in.ctx, dlLink)
+		if err != nil {
+			in.echo("Mirror test: %s failed: %s", host, err.Error())
+		} else {
+			in.echo("Mirror test: %s at %s/s", host, formatBytes(int64(speed)))
+		}
+		in.mirrorSpeeds[host] = speed
+	}
+
+	sort.SliceStable(urls, func(i, j int) bool {
+		return in.mirrorSpeeds[hostOf(urls[i])] > in.mirrorSpeeds[hostOf(urls[j])]
+	})
+}
+
+// hostOf returns the host dlLink is served from.
+func hostOf(dlLink string) string {
+	u, err := url.Parse(dlLink)
+	if err != nil {
+		return dlLink
+	}
+	return u.Host
+}
+
+// expectedSum returns the SHA-256 sum a is known to have from the release
+// manifest or the device config, or "" if there is none.
+func (in *install) expectedSum(a Asset) string {
+	if sum, ok := in.manifest[a.File]; ok {
+		return sum
+	}
+	if in.gapps != nil && a.File == in.gapps.File {
+		return strings.ToLower(in.gapps.Sha256)
+	}
+	if a.File == in.device.Twrp_file {
+		return strings.ToLower(in.device.Twrp_sha256)
+	}
+	return ""
+}
+
+// echoSum prints the SHA-256 sum of a download, and the sum it was checked
+// against if there was one, so users can compare or record it themselves.
+func (in *install) echoSum(sum, expected string) {
+	if expected == "" {
+		in.echo("    sha256 %s", sum)
+		return
+	}
+	in.echo("    sha256 %s (expected %s)", sum, expected)
+}
+
+// refresh downloads a again if it changed on the server since an earlier
+// run downloaded it. Not being able to check isn't fatal, the copy we have is
+// verified like any other file.
+func (in *install) refresh(a Asset) error {
+	sum, err := remote.Download(in.ctx, a.URL, a.File, a.Size)
+	if err != nil {
+		if cerr := in.ctxError(); cerr != nil {
+			return cerr
+		}
+		in.echo("Warning: couldn't check %s for changes, using the copy we have: %s", a.File, err.Error())
+		return nil
+	}
+	in.echoSum(sum, in.expectedSum(a))
+	in.sums[a.File] = sum
+	return nil
+}
+
+// download fetches a, moving on to the next mirror when a download fails or
+// doesn't match its expected sum since retrying a stale or corrupt mirror
+// tends to give back the same bad file.
+func (in *install) download(a Asset) error {
+	expected := in.expectedSum(a)
+
+	var lastErr error
+	for _, dlLink := range in.mirrorURLs(a.URL) {
+		sum, err := remote.Download(in.ctx, dlLink, a.File, a.Size)
+		if err == nil {
+			err = checkSize(a)
+		}
+		if err == nil && expected != "" && sum != expected {
+			err = fmt.Errorf("checksum mismatch (sha256 %s, expected %s)", sum, expected)
+		}
+		if err != nil {
+			os.Remove(a.File)
+			if in.ctx.Err() != nil {
+				return err
+			}
+			in.echo("Failed to download %s from %s: %s", a.Name, dlLink, err.Error())
+			lastErr = err
+			continue
+		}
+
+		in.echo("Got %s from %s", a.File, dlLink)
+		in.echoSum(sum, expected)
+		in.sums[a.File] = sum
+		return nil
+	}
+	return lastErr
+}
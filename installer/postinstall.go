@@ -0,0 +1,83 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package installer
+
+import (
+	"strings"
+	"time"
+
+	"../android"
+)
+
+// bootTimeout is how long to wait for Android to finish booting after the
+// install, which takes a while the first time.
+const bootTimeout = 10 * time.Minute
+
+const (
+	// nethunterPackage is the Nethunter app.
+	nethunterPackage = "com.offsec.nethunter"
+	// chrootDir is where the Nethunter filesystem installs the Kali chroot.
+	chrootDir = "/data/local/nhsystem"
+)
+
+// verifyInstall waits for the device to boot after the install and checks
+// that the Nethunter app and Kali chroot made it onto the device. Failed
+// checks are reported but don't fail the install, which already finished.
+func (in *install) verifyInstall() {
+	in.echo("Waiting for your device to boot to verify the install...")
+	if err := in.adb.WaitUntil(android.DeviceReappeared, bootTimeout); err != nil {
+		in.echo("Warning: your device didn't come back, unable to verify the install")
+		return
+	}
+	deadline := time.Now().Add(bootTimeout)
+	for {
+		if booted, _ := in.adb.GetProp("sys.boot_completed"); booted == "1" {
+			break
+		}
+		if time.Now().After(deadline) || in.ctx.Err() != nil {
+			in.echo("Warning: your device didn't finish booting, unable to verify the install")
+			return
+		}
+		in.sleep(5 * time.Second)
+	}
+
+	in.checkApps()
+}
+
+// checkApps checks the Nethunter app and Kali chroot are on the booted
+// device, reporting whether both are.
+func (in *install) checkApps() bool {
+	packages, _ := in.adb.ShellOutput("pm list packages " + nethunterPackage)
+	app := in.reportCheck("Nethunter app installed", strings.Contains(packages, "package:"+nethunterPackage))
+
+	// the chroot is only readable by root
+	chroot, err := in.adb.ShellOutput("su -c 'ls " + chrootDir + "'")
+	if err != nil || strings.TrimSpace(chroot) == "" {
+		chroot, _ = in.adb.ShellOutput("ls " + chrootDir)
+	}
+	return in.reportCheck("Kali chroot installed", strings.Contains(chroot, "kali")) && app
+}
+
+// reportCheck prints the outcome of a verification check and returns it.
+func (in *install) reportCheck(name string, ok bool) bool {
+	result := "PASS"
+	if !ok {
+		result = "FAIL"
+	}
+	in.echo("    [%s] %s", result, name)
+	return ok
+}
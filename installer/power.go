@@ -0,0 +1,66 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package installer
+
+import (
+	"context"
+
+	"../android"
+)
+
+// requiresCharging reports whether the device has to be plugged into power
+// while it is flashed.
+func (in *install) requiresCharging() bool {
+	return in.opts.RequireCharging || in.device.Require_charging
+}
+
+// checkCharging makes sure the device is plugged into power when that is
+// required, giving the user a chance to plug it in. Devices that can't report
+// whether they are charging only get a warning.
+func (in *install) checkCharging() error {
+	if !in.requiresCharging() {
+		return nil
+	}
+	charging, err := in.adb.Charging()
+	if err != nil {
+		in.echo("Warning: unable to tell whether your device is charging: " + err.Error())
+		return nil
+	}
+	if charging {
+		return nil
+	}
+
+	in.echo(MsgNotCharging)
+	in.opts.WaitForUser("Press enter once your device is charging")
+	if err := in.ctxError(); err != nil {
+		return err
+	}
+	if charging, err = in.adb.Charging(); err == nil && !charging {
+		return newError("Your device isn't charging", ErrorPrereqs, nil)
+	}
+	return nil
+}
+
+// CheckCharging checks the device booted into Android is plugged into power
+// when Options.RequireCharging is set, before anything reboots it.
+func CheckCharging(ctx context.Context, opts Options) error {
+	in := newInstall(ctx, Device{}, opts)
+	if status, err := in.adb.Status(); err != nil || status != android.DeviceConnected {
+		return nil
+	}
+	return in.checkCharging()
+}
@@ -0,0 +1,265 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package installer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"../android"
+	"../remote"
+)
+
+// sdcardWritable reports whether /sdcard is usable in TWRP. It is missing on
+// encrypted devices that TWRP couldn't decrypt since it lives on /data.
+func sdcardWritable(adb *android.AdbClient) bool {
+	output, err := adb.ShellOutput("if [ -w /sdcard/ ]; then echo writable; fi")
+	return err == nil && strings.Contains(output, "writable")
+}
+
+// checkSdcardOrFallback makes sure there is somewhere to push files to,
+// trying to mount /data before falling back to /tmp.
+func (in *install) checkSdcardOrFallback() {
+	in.pushDir = "/sdcard"
+	if sdcardWritable(&in.adb) {
+		return
+	}
+
+	in.echo("/sdcard isn't available, trying to mount /data...")
+	in.adb.Shell("twrp mount /data")
+	if sdcardWritable(&in.adb) {
+		return
+	}
+
+	in.echo(MsgSdcardUnavailable)
+	in.pushDir = "/tmp"
+}
+
+// dfCmd returns the command that reports the free space in dir in KiB.
+func dfCmd(dir string) string {
+	return "df -k " + android.ShellQuote(dir)
+}
+
+// deviceFreeSpace returns how many bytes are free in dir on the device.
+func deviceFreeSpace(adb *android.AdbClient, dir string) (int64, error) {
+	output, err := adb.ShellOutput(dfCmd(dir))
+	if err != nil {
+		return 0, err
+	}
+
+	// df reports "... [available] [use%] [mounted on]" in KiB after a header
+	// line. Long filesystem names can push the numbers onto a line of their
+	// own so count from the end.
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(lines) < 2 || len(fields) < 3 {
+		return 0, fmt.Errorf("unable to read free space of %s on device: %s", dir, strings.TrimSpace(output))
+	}
+	available, err := strconv.ParseInt(fields[len(fields)-3], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unable to read free space of %s on device: %s", dir, strings.TrimSpace(output))
+	}
+	return available * 1024, nil
+}
+
+// checkTmpSpace makes sure file fits in /tmp when falling back to it, since
+// it is backed by the device's RAM and big zips fail there with cryptic
+// errors.
+func (in *install) checkTmpSpace(file string) error {
+	if in.pushDir != "/tmp" {
+		return nil
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	free, err := deviceFreeSpace(&in.adb, in.pushDir)
+	if err != nil {
+		in.echo("Warning: %s", err.Error())
+		return nil
+	}
+
+	in.echo("%s needs %s, %s free in /tmp", file, formatBytes(info.Size()), formatBytes(free))
+	if info.Size() > free {
+		return fmt.Errorf("not enough room in /tmp for %s, format data in TWRP (Wipe > Format Data) to make /sdcard usable and re-run the installer", file)
+	}
+	return nil
+}
+
+// md5Cmd returns the command that hashes the file at path.
+func md5Cmd(path string) string {
+	return "md5sum " + android.ShellQuote(path)
+}
+
+// deviceMD5 returns the md5 sum of path on the device.
+func deviceMD5(adb *android.AdbClient, path string) (string, error) {
+	output, err := adb.ShellOutput(md5Cmd(path))
+	if err != nil {
+		return "", err
+	}
+
+	// md5sum reports "[sum]  [path]"
+	fields := strings.Fields(output)
+	if len(fields) == 0 || len(fields[0]) != 32 {
+		return "", fmt.Errorf("unable to hash %s on device: %s", path, strings.TrimSpace(output))
+	}
+	return fields[0], nil
+}
+
+// pushPollInterval is how often the size of a file being pushed is checked
+// on the device to report progress.
+const pushPollInterval = time.Second
+
+// push copies file to dst on the device, reporting progress like downloads
+// do by watching the copy grow on the device.
+func (in *install) push(file, dst string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- in.adb.Push(file, dst)
+	}()
+
+	t := time.NewTicker(pushPollInterval)
+	defer t.Stop()
+	var p remote.Progress
+	for {
+		select {
+		case <-t.C:
+			// adb writes to a temporary name on some versions, leaving
+			// nothing to measure until it is done
+			if size, err := in.adb.FileSize(dst); err == nil {
+				p.Print(size, info.Size())
+			}
+		case err := <-done:
+			if err == nil {
+				p.Print(info.Size(), info.Size())
+			}
+			p.Done()
+			return err
+		}
+	}
+}
+
+// pushIfNeeded pushes file to the device unless an identical copy is already
+// there, which makes re-running the installer after a failed push fast. The
+// local file is hashed while the device hashes its copy, and the pushed copy
+// is verified afterwards whenever the device is able to hash it.
+func (in *install) pushIfNeeded(file string) error {
+	type result struct {
+		sum string
+		err error
+	}
+	local := make(chan result, 1)
+	go func() {
+		sum, err := fileMD5(file)
+		local <- result{sum, err}
+	}()
+
+	devicePath := in.pushDir + "/" + file
+	remoteSum, _ := deviceMD5(&in.adb, devicePath)
+	localSum := <-local
+	if localSum.err != nil {
+		return localSum.err
+	}
+	if remoteSum == localSum.sum {
+		in.echo("%s is already on your device, skipping", file)
+		return nil
+	}
+
+	if err := in.checkTmpSpace(file); err != nil {
+		return err
+	}
+	if err := in.push(file, devicePath); err != nil {
+		return err
+	}
+
+	remoteSum, err := deviceMD5(&in.adb, devicePath)
+	if err != nil {
+		in.echo("Warning: unable to verify %s on your device: %s", file, err.Error())
+		return nil
+	}
+	if remoteSum != localSum.sum {
+		return fmt.Errorf("%s was corrupted while pushing (md5 %s, expected %s)", file, remoteSum, localSum.sum)
+	}
+	return nil
+}
+
+// zipMagics start every zip file, the second one empty zips.
+var zipMagics = []string{"PK\x03\x04", "PK\x05\x06"}
+
+// checkZip makes sure file really is a zip. Servers sometimes answer with an
+// error page and a 200 status, which is saved under the zip's name and only
+// fails once TWRP tries to install it.
+func checkZip(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+	for _, magic := range zipMagics {
+		if bytes.HasPrefix(head, []byte(magic)) {
+			return nil
+		}
+	}
+	if text := bytes.TrimSpace(head); bytes.HasPrefix(text, []byte("<")) || bytes.HasPrefix(text, []byte("{")) {
+		return fmt.Errorf("%s is a web page, not a zip, the download most likely returned an error page", file)
+	}
+	return fmt.Errorf("%s is not a zip", file)
+}
+
+// ensureZip checks file is a zip before it is pushed, downloading it again
+// when it isn't.
+func (in *install) ensureZip(file string) error {
+	err := checkZip(file)
+	if err == nil {
+		return nil
+	}
+	in.echo("Warning: " + err.Error())
+
+	var a Asset
+	for _, asset := range in.assets() {
+		if asset.File == file {
+			a = asset
+		}
+	}
+	if a.URL == "" || in.opts.Offline {
+		return newError("Failed to verify "+file, ErrorRemote, err)
+	}
+	in.echo("Downloading %s again...", file)
+	remote.Discard(file)
+	if err := in.download(a); err != nil {
+		return remoteError("Failed to download "+a.Name, err)
+	}
+	if err := checkZip(file); err != nil {
+		return newError("Failed to verify "+file, ErrorRemote, err)
+	}
+	return nil
+}
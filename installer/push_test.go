@@ -0,0 +1,51 @@
+package installer
+
+import (
+	"os/exec"
+	"testing"
+)
+
+// TestDeviceCmds runs the commands built for a file name with spaces and
+// shell syntax in it against stand-ins for the device tools, checking that
+// each receives the path as a single argument.
+func TestDeviceCmds(t *testing.T) {
+	path := "/sdcard/my update's $HOME;`reboot`.zip"
+	stubs := `twrp() { printf '%s|' "$#" "$@"; }
+md5sum() { printf '%s|' "$#" "$@"; }
+df() { printf '%s|' "$#" "$@"; }
+`
+	tests := []struct {
+		cmd, want string
+	}{
+		{installCmd(path), "2|install|" + path + "|"},
+		{md5Cmd(path), "1|" + path + "|"},
+		{dfCmd(path), "2|-k|" + path + "|"},
+	}
+	for _, tt := range tests {
+		out, err := exec.Command("sh", "-c", stubs+tt.cmd).Output()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.cmd, err)
+		}
+		if string(out) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.cmd, out, tt.want)
+		}
+	}
+}
+
+func TestORSSafeName(t *testing.T) {
+	tests := []struct {
+		name string
+		safe bool
+	}{
+		{"lineage-14.1-20171008-UNOFFICIAL-hammerhead.zip", true},
+		{"open_gapps-arm-7.1-mini+extra.zip", true},
+		{"my update.zip", false},
+		{"update\ninstall evil.zip", false},
+		{"it's.zip", false},
+	}
+	for _, tt := range tests {
+		if got := orsSafeName.MatchString(tt.name); got != tt.safe {
+			t.Errorf("orsSafeName.MatchString(%q) = %v, want %v", tt.name, got, tt.safe)
+		}
+	}
+}
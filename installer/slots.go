@@ -0,0 +1,61 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package installer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Slots, see Options.Slot.
+const (
+	SlotA       = "a"
+	SlotB       = "b"
+	SlotCurrent = "current"
+	SlotOther   = "other"
+)
+
+// Slots lists the valid slots.
+var Slots = []string{SlotA, SlotB, SlotCurrent, SlotOther}
+
+// useSlot makes every flash target the slot from the options and makes that
+// slot the active one, so the device boots what was flashed. It fails on
+// devices without A/B slots.
+func (in *install) useSlot() error {
+	slot := in.opts.Slot
+	if slot == "" || slot == SlotCurrent {
+		return nil
+	}
+	hasSlots, err := in.fastboot.HasSlots()
+	if err != nil {
+		return newError("Failed to read the device's slots", ErrorFastboot, err)
+	}
+	if !hasSlots {
+		return newError(fmt.Sprintf("The %s doesn't have A/B slots, --slot=%s can't be used", in.device.Common_name, slot), ErrorUserInput, nil)
+	}
+
+	in.echo("Flashing slot %s and making it the active slot", strings.ToUpper(slot))
+	in.fastboot.Slot = slot
+	if err := in.fastboot.SetActive(slot); err != nil {
+		return newError("Failed to switch to slot "+slot, ErrorFastboot, err)
+	}
+	// "other" is relative to the active slot, which is now the other one
+	if slot == SlotOther {
+		in.fastboot.Slot = ""
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package installer
+
+import "fmt"
+
+// Install steps in the order they happen, reported through Options.OnStep.
+const (
+	StepStart             = "start"
+	StepIdentify          = "identify"
+	StepUnlock            = "unlock"
+	StepDownload          = "download"
+	StepFlashTwrp         = "flash-twrp"
+	StepWipe              = "wipe"
+	StepPush              = "push"
+	StepInstall           = "install"
+	StepInstallFilesystem = "install-filesystem"
+	StepDone              = "done"
+)
+
+var steps = []string{
+	StepStart,
+	StepIdentify,
+	StepUnlock,
+	StepDownload,
+	StepFlashTwrp,
+	StepWipe,
+	StepPush,
+	StepInstall,
+	StepInstallFilesystem,
+	StepDone,
+}
+
+// stepPercent returns how far through the install step is.
+func stepPercent(step string) float64 {
+	for i, s := range steps {
+		if s == step {
+			return 100 * float64(i) / float64(len(steps)-1)
+		}
+	}
+	return 0
+}
+
+// StepInfo describes a step that can be picked with Options.Steps.
+type StepInfo struct {
+	Name        string
+	Description string
+}
+
+// selectableSteps are the steps that can be run on their own, in order. The
+// rest always run.
+var selectableSteps = []StepInfo{
+	{StepUnlock, "check the bootloader is unlocked and unlock it if not"},
+	{StepDownload, "download and verify the files for the install"},
+	{StepFlashTwrp, "flash TWRP to the recovery partition"},
+	{StepWipe, "wipe the device in TWRP"},
+	{StepPush, "copy the zips to the device"},
+	{StepInstall, "install the copied zips (or the kernel for boot installs)"},
+	{StepInstallFilesystem, "install the Nethunter filesystem"},
+}
+
+// stepNeeds lists the steps that can't run without another one.
+var stepNeeds = map[string][]string{
+	StepInstall: {StepPush},
+}
+
+// SelectableSteps returns the steps that can be picked with Options.Steps,
+// in the order they run.
+func SelectableSteps() []StepInfo {
+	return selectableSteps
+}
+
+// CheckSteps makes sure every step in selected can be picked and that the
+// steps they need are picked too.
+func CheckSteps(selected []string) error {
+	has := func(step string) bool {
+		for _, s := range selected {
+			if s == step {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, s := range selected {
+		known := false
+		for _, info := range selectableSteps {
+			known = known || info.Name == s
+		}
+		if !known {
+			return fmt.Errorf("unknown step %q", s)
+		}
+		for _, need := range stepNeeds[s] {
+			if !has(need) {
+				return fmt.Errorf("step %q needs step %q", s, need)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package installer
+
+const MsgIncompleteZip = `
+Hmm, looks like your installer is a missing a few things.
+
+Are you running this install script outside the directory you unzipped the installer in?
+`
+
+const msgFixAdb = `
+Please ensure that:
+
+1. Your device is connected to your computer over USB
+2. You have USB Debugging enabled (see above for instructions)
+3. You unlock your device and tap "OK" if you see a dialog asking you
+   to allow USB Debugging for your computer's RSA key fingerprint
+
+If you are on Windows, please ensure you have the Google USB Driver properly
+installed for your device as described in HELP.txt (this is the main source of
+problems on Windows!)
+
+Go ahead and re-run the installer when you're ready.
+`
+const MsgAdbIssue = "\nHmm, there was an issue communicating with your device.\n" + msgFixAdb
+
+const MsgFastbootNoDeviceFound = `
+Hmm, your device can't be found. Please ensure that your device is connected to
+your computer over USB.
+`
+
+const MsgFixPerms = `
+It looks like you are missing some USB permissions.
+
+Please follow the instructions below depending on your platform:
+
+Linux
+-----
+
+On certain Linux distributions (Ubuntu 14.04 for example), you will need to
+explicitly add permissions to access USB devices:
+
+1. Disconnect your device from USB
+
+2. Run this in a terminal (requires sudo):
+
+   $ wget -S -O - https://source.android.com/source/51-android.txt | sed "s/<username>/$USER/" | sudo tee >/dev/null /etc/udev/rules.d/51-android.rules; sudo udevadm control --reload-rules
+
+3. Re-connect your device over USB and re-run this installer
+
+Windows
+-------
+
+Please ensure you have the Google USB Driver properly installed for your device
+as described in HELP.txt (this is the main source of problems on Windows!)
+
+`
+
+const MsgDeadURLs = `
+Hmm, some of the files needed to install Nethunter can't be downloaded right now
+(see above). Nothing has been changed on your device.
+
+Please check your internet connection and try again later, or download the files
+manually into the installer directory.
+`
+
+const MsgTwrpNotBooted = `
+Hmm, TWRP did not boot. Your device seems to be running a different recovery,
+which usually means the wrong or an old TWRP image was flashed.
+
+Please re-run the installer. If this keeps happening, boot into the bootloader
+and flash the TWRP image from the installer dir manually with "fastboot flash
+recovery <image>".`
+
+const MsgSdcardUnavailable = `
+Hmm, TWRP can't access /sdcard on your device. This usually means your data
+partition is encrypted and TWRP wasn't able to decrypt it.
+
+The installer will try to continue using /tmp instead, which is limited by the
+amount of RAM on your device. If installing fails, format data in TWRP
+(Wipe > Format Data) to remove the encryption and re-run the installer. This
+will erase everything on your internal storage!
+`
+
+const MsgGappsTerms = `
+Google Apps are Google's proprietary software and are licensed by Google, not
+by Nethunter. By installing them you agree to Google's Terms of Service
+(https://policies.google.com/terms) and confirm that using them is allowed
+where you live.
+`
+
+const MsgNotCharging = `
+Your device isn't charging. Installing takes a while and reboots several times,
+and a device that runs out of power partway can be left unbootable. Please
+plug it into a charger or a USB port that charges it.
+`
+
+const MsgFormatData = `
+About to format the data partition. This removes encryption so TWRP can use
+/sdcard, but it will ERASE EVERYTHING on your internal storage, including
+photos, downloads and anything else not backed up elsewhere!
+`
+
+const MsgDeviceMismatch = `
+Hmm, the connected device doesn't match the configuration the installer was
+about to use (see above). Flashing images built for another device can brick
+your phone, so nothing has been flashed.
+
+If you are absolutely sure this is the right configuration, re-run the
+installer with --force.
+`
+
+const MsgOldTools = `%s %s is older than platform-tools %s and may not work with
+newer devices or TWRP. Please update the installer or the bundled Android
+platform-tools.`
+
+const MsgBootloaderTooOld = `
+Hmm, your device's bootloader (%s) is too old for this version of Nethunter,
+which needs at least %s. Installing anyway would likely leave your device stuck
+in a bootloop.
+
+Please update your device to the latest stock firmware first and then re-run
+the installer.
+`
+
+const MsgAndroidVersionMismatch = `
+Warning: your device is running %s (API %d) but this NethunterOS is built on
+%s (API %d). Installing over it without wiping often ends in a bootloop, so a
+clean install (without --no-wipe, and declining to update) is recommended.
+`
+
+const MsgAssumeUnlocked = `
+Skipping the bootloader unlock check (--assume-unlocked). You are responsible
+for the bootloader really being unlocked: if it isn't, flashing will fail
+partway through the install.
+`
+
+const MsgUnlockSuccess = `
+Successfully unlocked bootloader!
+
+Your device will need to reboot before continuing. It will factory reset, so
+this reboot can take a few minutes longer than usual.
+
+To continue the installation process, please re-run this script after your
+device completely boots up and you have re-enabled USB Debugging.
+`
+
+const MsgStuckAfterUnlock = `
+Your device keeps returning to the bootloader instead of booting. Select
+"Start" with the volume buttons and press the power button to boot it and let
+it finish the factory reset.
+`
+
+const MsgUnlockOnly = `
+Next steps:
+
+1. Let your device finish booting and set it up (the unlock wiped it)
+2. Enable USB Debugging again in Settings > Developer options
+3. Re-run the installer without --unlock-only to install Nethunter
+`
+
+const MsgRecoveryFlashed = `
+TWRP recovery has been flashed!
+
+Your device is still in the bootloader. Use the volume buttons to select
+"Recovery mode" and press the power button to boot into TWRP.
+`
+
+const MsgRebootRecovery = `
+Your device couldn't be rebooted into recovery automatically. Use the volume
+buttons to select "Recovery mode" in the bootloader and press the power button
+to boot into TWRP.
+`
+
+const MsgClockWrong = `
+Hmm, the download servers' certificates look expired or not yet valid to this
+computer, which almost always means its date and time are wrong.
+
+Please set your system clock to the correct date and time (turning on
+automatic time sync usually fixes it) and re-run the installer. If that's not
+possible, --insecure skips certificate checks, at the risk of downloading
+tampered files.
+`
+
+const MsgAborted = `
+The install was aborted before anything was flashed, but your device is still
+in the bootloader. It can be rebooted back to Android, or you can do this
+yourself by selecting "Start" with the volume buttons and pressing power.
+`
+
+const MsgKernelFlashed = `
+The Nethunter kernel has been flashed! Your device will now reboot.
+`
+
+const MsgSuccess = `
+Installation of base OS complete!
+
+The first boot will take 2-3 mins as Nethunter sets up your device so please be
+patient.
+
+Rebooting into NethunterOS...were almost there!  We still need to install Kali filesystem!
+`
+
+const MsgReenable = `
+Please reenable ADB one more time to flash the filesystem to device.
+
+1. Connect your device to your computer over USB
+
+2. Enable USB Debugging on your device:
+
+    1)  Go to the Settings app and scroll down to
+        the System section
+
+        NOTE: If you already have "Developer options"
+        under System then go directly to #5
+
+    2)  Tap on "About phone"
+    3)  Tap "Build number" 7 times until you get a message
+        that says you are now a developer
+    4)  Go back to the main Settings app
+    5)  Tap on "Developer options"
+    6)  Ensure that "USB debugging" is enabled
+    7)  You may need to restart adb if you don't get an RSA key box (adb kill-server)
+    8)  Tap "OK" if you see a dialog asking you to allow
+        USB Debugging for your computer's RSA key fingerprint
+
+`
+const MsgNoReboot = `
+Your device has been left in TWRP (--no-reboot) so you can flash anything
+else you need. When you're done, go to Reboot > System > Do Not Install.
+`
+
+const MsgFinished = `
+All done!  
+
+Run the Nethunter app first to discovery chroot.  Make sure to grant super su
+permissions.
+
+Terminal 
+`
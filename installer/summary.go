@@ -0,0 +1,102 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package installer
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Summary records what a successful install did, for the user's records and
+// for bug reports.
+type Summary struct {
+	Device     string   `json:"device"`
+	Product    string   `json:"product"`
+	RomVersion string   `json:"rom_version"`
+	Components []string `json:"components"`
+	Seconds    int64    `json:"seconds"`
+	Downloaded int64    `json:"downloaded_bytes"`
+	// SHA-256 sums of the files that were downloaded or verified, by name
+	Sums map[string]string `json:"sha256,omitempty"`
+}
+
+func (s Summary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Install summary:\n")
+	fmt.Fprintf(&b, "    %-12s %s (%s)\n", "Device:", s.Device, s.Product)
+	fmt.Fprintf(&b, "    %-12s %s\n", "ROM:", s.RomVersion)
+	fmt.Fprintf(&b, "    %-12s %s\n", "Installed:", strings.Join(s.Components, ", "))
+	fmt.Fprintf(&b, "    %-12s %s\n", "Time:", time.Duration(s.Seconds)*time.Second)
+	fmt.Fprintf(&b, "    %-12s %s", "Downloaded:", formatBytes(s.Downloaded))
+
+	var files []string
+	for file := range s.Sums {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for i, file := range files {
+		label := ""
+		if i == 0 {
+			label = "SHA-256:"
+		}
+		fmt.Fprintf(&b, "\n    %-12s %s  %s", label, s.Sums[file], file)
+	}
+	return b.String()
+}
+
+// romVersion derives a ROM (or kernel) version from its file name, e.g.
+// "lineage-14.1-20171008-UNOFFICIAL-hammerhead.zip" gives
+// "lineage-14.1-20171008-UNOFFICIAL".
+func romVersion(d Device) string {
+	file := d.Nhos_file
+	if d.bootOnly() {
+		file = d.Boot_file
+	}
+	v := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	for _, name := range []string{d.Product_name, d.Variant} {
+		if name != "" {
+			v = strings.TrimSuffix(v, "-"+name)
+		}
+	}
+	return v
+}
+
+// installed records that component made it onto the device. The TWRP
+// sequence can be retried, so components are only recorded once.
+func (in *install) installed(component string) {
+	for _, c := range in.components {
+		if c == component {
+			return
+		}
+	}
+	in.components = append(in.components, component)
+}
+
+func (in *install) summary() Summary {
+	return Summary{
+		Device:     in.device.Common_name,
+		Product:    in.device.Product_name,
+		RomVersion: romVersion(in.device),
+		Components: in.components,
+		Seconds:    int64(time.Since(in.started) / time.Second),
+		Downloaded: in.downloaded,
+		Sums:       in.sums,
+	}
+}
@@ -0,0 +1,820 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package installer
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"../android"
+)
+
+// rebootTimeout is how long to wait for the device to show up after a reboot.
+const rebootTimeout = 2 * time.Minute
+
+// isTransient reports whether err is a communication error worth retrying
+// rather than something like TWRP rejecting a zip.
+func isTransient(err error) bool {
+	if ie, ok := err.(*Error); ok {
+		err = ie.Err
+	}
+	return android.IsTransient(err)
+}
+
+// retryTransient runs fn, retrying it up to Options.Retries times as long as
+// it fails with a transient error. The device is put back into the bootloader
+// before each retry.
+func (in *install) retryTransient(fn func() error) error {
+	err := fn()
+	for i := 0; i < in.opts.Retries && err != nil && isTransient(err); i++ {
+		in.echo("")
+		in.echo("Lost connection to your device (%s), retrying (%d of %d)...", err.Error(), i+1, in.opts.Retries)
+		if rerr := in.resetToBootloader(); rerr != nil {
+			return newError("Failed to reconnect to your device", ErrorAdb, rerr)
+		}
+		err = fn()
+	}
+	return err
+}
+
+// resetToBootloader gets the device back into the bootloader so a TWRP
+// sequence can be started over.
+func (in *install) resetToBootloader() error {
+	if status, err := in.fastboot.Status(); err == nil && status == android.DeviceConnected {
+		return nil
+	}
+
+	status, err := in.adb.Status()
+	if err != nil {
+		return err
+	}
+	if status != android.DeviceConnected {
+		return errors.New("no device found, please check your USB cable")
+	}
+
+	in.echo("Rebooting your device into bootloader...")
+	if err = in.adb.Reboot("bootloader"); err != nil {
+		return err
+	}
+	if err = in.fastboot.WaitUntil(android.DevicePresent, rebootTimeout); err != nil {
+		return errors.New("device didn't reboot into bootloader")
+	}
+	return nil
+}
+
+// switchFastboot reboots between the bootloader and fastbootd so that the
+// device is in fastbootd if userspace is set and in the bootloader otherwise.
+func (in *install) switchFastboot(userspace bool) error {
+	// bootloaders that don't know is-userspace aren't fastbootd
+	isUserspace, _ := in.fastboot.IsUserspace()
+	if isUserspace == userspace {
+		return nil
+	}
+
+	var err error
+	if userspace {
+		in.echo("Rebooting your device into fastbootd...")
+		err = in.fastboot.RebootFastbootd()
+	} else {
+		in.echo("Rebooting your device into bootloader...")
+		err = in.fastboot.RebootBootloader()
+	}
+	if err != nil {
+		return err
+	}
+	return in.fastboot.WaitUntil(android.DeviceReappeared, rebootTimeout)
+}
+
+// flash writes image to partition from fastbootd if the partition needs it
+// and from the bootloader otherwise.
+func (in *install) flash(partition, image string) error {
+	in.flashed = true
+	if err := in.switchFastboot(in.device.needsFastbootd(partition)); err != nil {
+		return err
+	}
+	return in.fastboot.Flash(partition, image)
+}
+
+// flashPartitionImages flashes the device's dtbo and vendor_boot images, if
+// it has any. A/B devices get them in both slots since the ROM is installed
+// to the inactive slot and then boots from it.
+func (in *install) flashPartitionImages() error {
+	return in.flashImages(in.device.partitionImages())
+}
+
+// flashImages flashes each of images to its partition, in both slots on A/B
+// devices.
+func (in *install) flashImages(images []partitionImage) error {
+	if len(images) == 0 {
+		return nil
+	}
+	hasSlots, err := in.fastboot.HasSlots()
+	if err != nil {
+		in.echo("Warning: unable to determine whether the device has A/B slots: " + err.Error())
+	}
+
+	for _, p := range images {
+		in.echo("Flashing the %s", p.name)
+		in.flashed = true
+		err := in.switchFastboot(in.device.needsFastbootd(p.partition))
+		if err == nil && hasSlots {
+			err = in.fastboot.FlashAllSlots(p.partition, p.file)
+		} else if err == nil {
+			err = in.fastboot.Flash(p.partition, p.file)
+		}
+		if err != nil {
+			return newError("Failed to flash the "+p.name, ErrorFastboot, err)
+		}
+		in.installed(p.name)
+	}
+	return nil
+}
+
+// bootImageMagic starts every Android boot and recovery image.
+const bootImageMagic = "ANDROID!"
+
+// useTwrpImage replaces the device's TWRP with the local image at path,
+// which is used as is instead of being downloaded.
+func (in *install) useTwrpImage(path string) error {
+	if in.device.bootOnly() {
+		return newError("The "+in.device.Common_name+" is installed without TWRP, there is no TWRP image to replace", ErrorUserInput, nil)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return newError("Failed to read TWRP image", ErrorUserInput, err)
+	}
+	defer f.Close()
+	magic := make([]byte, len(bootImageMagic))
+	if _, err := io.ReadFull(f, magic); err != nil || string(magic) != bootImageMagic {
+		return newError(path+" is not a recovery image", ErrorUserInput, err)
+	}
+
+	in.echo("Using the TWRP image %s instead of downloading one", path)
+	in.device.Twrp_file = path
+	in.device.Twrp_url = ""
+	in.device.Twrp_size = 0
+	in.device.Twrp_sha256 = ""
+	return nil
+}
+
+// verifyTwrpImage checks the TWRP image against its SHA-256 from the release
+// manifest or the device config, right before it goes to the device. Images
+// without a known sum are left to the recovery readback.
+func (in *install) verifyTwrpImage(image string) error {
+	expected := in.expectedSum(Asset{File: image})
+	if expected == "" {
+		return nil
+	}
+	actual, err := fileHash(image, sha256.New())
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return fmt.Errorf("%s is corrupt (sha256 %s, expected %s)", image, actual, expected)
+	}
+	return nil
+}
+
+// flashTwrp verifies the local TWRP image before flashing it to the recovery
+// partition.
+func (in *install) flashTwrp() error {
+	image := in.device.Twrp_file
+	if err := in.verifyTwrpImage(image); err != nil {
+		return newError("Failed to verify TWRP image", ErrorTWRP, err)
+	}
+	if err := in.flash("recovery", image); err != nil {
+		return newError("Failed to flash TWRP Recovery", ErrorTWRP, err)
+	}
+
+	// TWRP is probed once booted when it can't be read back
+	err := in.fastboot.VerifyPartition("recovery", image)
+	if err == android.ErrReadbackUnsupported {
+		in.echo("Your device can't read back recovery, TWRP will be checked once it boots")
+	} else if err != nil {
+		return newError("Failed to verify TWRP Recovery", ErrorTWRP, err)
+	} else {
+		in.echo("Verified TWRP Recovery on your device")
+	}
+	in.twrpFlashed = true
+	return nil
+}
+
+// runBootOnly finishes a "boot" install by flashing the Nethunter kernel boot
+// image instead of installing through TWRP.
+func (in *install) runBootOnly() error {
+	if !in.selected(StepInstall) {
+		in.skipStep(StepInstall)
+		in.opts.OnSummary(in.summary())
+		return nil
+	}
+	in.opts.WaitForUser("Press enter to start the installation")
+
+	if err := in.step(StepInstall); err != nil {
+		return err
+	}
+	if err := in.checkDevice(); err != nil {
+		return err
+	}
+	if err := in.useSlot(); err != nil {
+		return err
+	}
+	in.echo("Flashing the Nethunter kernel")
+	if err := in.flash("boot", in.device.Boot_file); err != nil {
+		return newError("Failed to flash Nethunter kernel", ErrorFastboot, err)
+	}
+	in.installed("Nethunter kernel")
+
+	if err := in.step(StepDone); err != nil {
+		return err
+	}
+	in.echo(MsgKernelFlashed)
+	if err := in.fastboot.Reboot(); err != nil {
+		in.echo("\nPlease reboot your device manually by selecting \"Start\" in the bootloader")
+		return newError("Failed to reboot", ErrorFastboot, err)
+	}
+	in.opts.OnSummary(in.summary())
+	return nil
+}
+
+// bootTwrp boots TWRP, rebooting into the recovery partition once TWRP has
+// been flashed there and otherwise verifying the local TWRP image and booting
+// it. A failed boot is usually caused by a bad flash, so the image is flashed
+// once more before retrying the boot.
+//
+// Devices with a twrp_mode of "flash" are only ever rebooted into recovery,
+// and those with "boot" never have TWRP flashed.
+func (in *install) bootTwrp() error {
+	image := in.device.Twrp_file
+	in.twrpReady = false
+	// only the bootloader can boot images or reboot into recovery
+	if err := in.switchFastboot(false); err != nil {
+		return newError("Failed to reboot into bootloader", ErrorFastboot, err)
+	}
+	if in.twrpFlashed {
+		err := in.fastboot.RebootRecovery()
+		if err == nil {
+			in.twrpReady = in.adb.WaitForRecovery(twrpBootTimeout) == nil
+			return nil
+		}
+		if !in.device.bootsTwrp() {
+			in.echo(MsgRebootRecovery)
+			in.opts.WaitForUser("Press enter once your device is booting into recovery")
+			return nil
+		}
+		// older fastboot can't reboot into recovery
+		in.echo("Failed to reboot into recovery, booting the TWRP image instead: %s", err.Error())
+	}
+
+	if err := in.verifyTwrpImage(image); err != nil {
+		return newError("Failed to verify TWRP image", ErrorTWRP, err)
+	}
+	if err := in.bootTwrpImage(image); err == nil {
+		return nil
+	}
+
+	if !in.device.flashesTwrp() {
+		if err := in.bootTwrpImage(image); err != nil {
+			return newError("Failed to boot TWRP", ErrorTWRP, err)
+		}
+		return nil
+	}
+	in.echo("Failed to boot TWRP, flashing recovery again and retrying...")
+	if err := in.flashTwrp(); err != nil {
+		return err
+	}
+	if err := in.switchFastboot(false); err != nil {
+		return newError("Failed to reboot into bootloader", ErrorFastboot, err)
+	}
+	if err := in.bootTwrpImage(image); err != nil {
+		return newError("Failed to boot TWRP", ErrorTWRP, err)
+	}
+	return nil
+}
+
+// twrpBootTimeout is how long TWRP gets to show up in adb after booting it.
+const twrpBootTimeout = 90 * time.Second
+
+// bootTwrpImage boots image and waits for TWRP to show up in adb, so the
+// install can carry on without the user saying when TWRP is ready. If it
+// doesn't show up in time, the user is left to say so instead.
+func (in *install) bootTwrpImage(image string) error {
+	err := in.fastboot.BootAndWait(image, &in.adb, twrpBootTimeout)
+	if err == android.ErrWaitTimeout {
+		in.echo("TWRP didn't show up in adb in time")
+		return nil
+	}
+	in.twrpReady = err == nil
+	return err
+}
+
+// waitForTwrp waits for adb to see the device once TWRP has booted and makes
+// sure it really is TWRP, since the "twrp" commands used from here on quietly
+// do nothing in stock recovery.
+func (in *install) waitForTwrp() error {
+	if err := in.adb.WaitUntil(android.DevicePresent, rebootTimeout); err != nil && !in.restartAdbServer() {
+		return newError("Failed to connect to TWRP", ErrorAdb, err)
+	}
+
+	output, err := in.adb.ShellOutput("command -v twrp")
+	if err != nil || strings.TrimSpace(output) == "" {
+		return newError(MsgTwrpNotBooted, ErrorTWRP, err)
+	}
+	if version, _ := in.adb.ShellOutput("getprop ro.twrp.version"); strings.TrimSpace(version) != "" {
+		in.echo("TWRP %s is ready", strings.TrimSpace(version))
+	}
+	return nil
+}
+
+// waitForTwrpAdb waits for adb to see TWRP after installing zips, which can
+// make the device drop off USB for a moment.
+func (in *install) waitForTwrpAdb() error {
+	if err := in.adb.WaitUntil(android.DevicePresent, rebootTimeout); err != nil {
+		return newError("Lost connection to TWRP", ErrorAdb, err)
+	}
+	return nil
+}
+
+// Data modes, see Options.DataMode.
+const (
+	DataWipe   = "wipe"
+	DataFormat = "format"
+	DataKeep   = "keep"
+)
+
+// DataModes lists the valid data modes.
+var DataModes = []string{DataWipe, DataFormat, DataKeep}
+
+// formatsData reports whether data is formatted before installing, which
+// the data mode overrides in both directions.
+func (in *install) formatsData() bool {
+	switch in.opts.DataMode {
+	case DataFormat:
+		return true
+	case DataKeep:
+		return false
+	}
+	return in.device.Format_data
+}
+
+// wipesData reports whether the device config wipes data before
+// installing.
+func (in *install) wipesData() bool {
+	for _, partition := range in.device.Wipe {
+		if partition == "data" {
+			return true
+		}
+	}
+	return false
+}
+
+// preWipe returns the partitions wiped before installing. Data is left out
+// when it is formatted or kept instead.
+func (in *install) preWipe() []string {
+	if in.opts.DataMode != DataFormat && in.opts.DataMode != DataKeep {
+		return in.device.Wipe
+	}
+	var partitions []string
+	for _, partition := range in.device.Wipe {
+		if partition != "data" {
+			partitions = append(partitions, partition)
+		}
+	}
+	if in.opts.DataMode == DataKeep {
+		in.echo("Keeping the data partition (--data-mode=keep)")
+	}
+	return partitions
+}
+
+// wipe runs "twrp wipe" for each of the given partitions in order.
+func (in *install) wipe(partitions []string) error {
+	// each wipe has to be confirmed on its own
+	if in.opts.ORS && !in.opts.ConfirmWipes {
+		var cmds []string
+		for _, partition := range partitions {
+			cmds = append(cmds, "wipe "+partition)
+		}
+		in.echo("Wiping %s...", strings.Join(partitions, ", "))
+		err := in.runORS(cmds)
+		if err == nil {
+			return nil
+		} else if err != android.ErrORSUnsupported {
+			// wiping again is harmless, so find the failing wipe one by one
+			in.echo("Warning: the wipe script failed (%s), wiping one partition at a time", err.Error())
+			if err := in.waitForTwrp(); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, partition := range partitions {
+		if in.opts.ConfirmWipes {
+			in.echo("About to wipe %s.", partition)
+			in.opts.WaitForUser("Press enter to wipe " + partition + ", or Ctrl-C to abort")
+			if err := in.ctxError(); err != nil {
+				return err
+			}
+			in.echo("Wipe of %s confirmed", partition)
+		}
+		in.echo("Wiping %s...", partition)
+		in.sleep(1000 * time.Millisecond)
+		if err := in.wipePartition(partition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wipePartition wipes partition, retrying once when TWRP is still there
+// after a failure since a single wipe can fail on a hiccup that leaves the
+// rest of the install fine.
+func (in *install) wipePartition(partition string) error {
+	err := in.adb.Shell("twrp wipe " + partition)
+	if err == nil {
+		return nil
+	}
+	in.echo("Warning: wiping %s failed: %s", partition, wipeOutput(err))
+	if err := in.waitForTwrp(); err != nil {
+		return err
+	}
+	in.echo("Retrying the wipe of %s...", partition)
+	if err = in.adb.Shell("twrp wipe " + partition); err != nil {
+		return newError(fmt.Sprintf("Failed to wipe %s: %s", partition, wipeOutput(err)), ErrorTWRP, err)
+	}
+	return nil
+}
+
+// wipeOutput describes a failed wipe by what TWRP printed, falling back to
+// the error when it printed nothing.
+func wipeOutput(err error) string {
+	var adbErr *android.AdbError
+	if errors.As(err, &adbErr) && strings.TrimSpace(adbErr.Output) != "" {
+		return strings.TrimSpace(adbErr.Output)
+	}
+	return err.Error()
+}
+
+// formatDataIfNeeded formats data when asked to by the user or the device
+// config, once the user has confirmed it. Formatting has to happen before
+// anything is pushed since it also erases /sdcard.
+func (in *install) formatDataIfNeeded() error {
+	if !in.formatsData() {
+		return nil
+	}
+
+	in.echo(MsgFormatData)
+	format, err := in.opts.Confirm("Format data and erase internal storage?")
+	if err != nil {
+		return newError("Failed to read input", ErrorUserInput, err)
+	}
+	if !format {
+		// preWipe left data to the format, so it still has to go
+		if in.opts.DataMode == DataFormat && in.wipesData() {
+			in.echo("Skipping format data, wiping it instead")
+			return in.wipe([]string{"data"})
+		}
+		in.echo("Skipping format data")
+		return nil
+	}
+
+	in.echo("Formatting data...")
+	if err := in.adb.Shell("twrp format data"); err != nil {
+		return newError("Failed to format data", ErrorTWRP, err)
+	}
+	return nil
+}
+
+// runInstallCmds runs the extra commands from the device config in TWRP.
+func (in *install) runInstallCmds(cmds []string) error {
+	for _, cmd := range cmds {
+		in.echo("Running %q...", cmd)
+		if err := in.adb.Shell(cmd); err != nil {
+			return newError("Failed to run "+cmd, ErrorTWRP, err)
+		}
+	}
+	return nil
+}
+
+// runORS runs cmds as one OpenRecoveryScript, warning when TWRP doesn't
+// support that so the caller can fall back to running them one at a time.
+func (in *install) runORS(cmds []string) error {
+	err := android.RunORS(&in.adb, cmds)
+	if err == android.ErrORSUnsupported {
+		in.echo("Warning: this TWRP can't run scripts, falling back to one command at a time")
+	}
+	return err
+}
+
+// zipInstall is a zip to install in TWRP along with what it provides.
+type zipInstall struct {
+	file      string
+	component string
+}
+
+// orsSafeName matches file names that can go in an OpenRecoveryScript,
+// which takes the rest of the line as the path with no way to quote it.
+var orsSafeName = regexp.MustCompile(`^[A-Za-z0-9._+-]+$`)
+
+// orsSafe reports whether every zip can be installed from a script, saying
+// why not when one can't.
+func (in *install) orsSafe(zips []zipInstall) bool {
+	for _, z := range zips {
+		if !orsSafeName.MatchString(z.file) {
+			in.echo("Warning: %q can't be installed from a script, installing one zip at a time", z.file)
+			return false
+		}
+	}
+	return true
+}
+
+// installZips installs previously pushed zips in order, as a single
+// OpenRecoveryScript when asked to and their names allow it.
+func (in *install) installZips(zips []zipInstall) error {
+	if in.opts.ORS && in.orsSafe(zips) {
+		var cmds []string
+		for _, z := range zips {
+			// scripts take the rest of the line as the path, unquoted
+			cmds = append(cmds, "install "+in.pushDir+"/"+z.file)
+		}
+		in.echo("Installing zips please keep your device connected...")
+		err := android.PushORS(&in.adb, cmds)
+		var job *android.DetachedJob
+		if err == nil {
+			job, err = in.runInTwrp("ors", "twrp runscript "+android.ORSPath)
+		}
+		switch {
+		case err == nil:
+			for _, z := range zips {
+				in.installed(z.component)
+			}
+			return nil
+		case job != nil && android.ORSUnsupported(job.Output()):
+			in.echo("Warning: this TWRP can't run scripts, falling back to one command at a time")
+		case err == android.ErrJobLost:
+			in.echo("TWRP restarted while running the install script, installing one zip at a time")
+		default:
+			return newError("Failed to flash zips", ErrorTWRP, err)
+		}
+	}
+
+	for _, z := range zips {
+		in.echo("Installing %s please keep your device connected...", z.component)
+		if err := in.installZip(z.file); err != nil {
+			return newError("Failed to flash "+z.component, ErrorTWRP, err)
+		}
+		in.installed(z.component)
+	}
+	return nil
+}
+
+// installZip installs a zip previously pushed to the device. It is only
+// installed again when TWRP restarted before finishing it, since TWRP keeps
+// installing through a lost adb connection.
+func (in *install) installZip(file string) error {
+	cmd := installCmd(in.pushDir + "/" + file)
+	_, err := in.runInTwrp("install-"+file, cmd)
+	if err == android.ErrJobLost {
+		in.echo("TWRP restarted before %s was installed, installing it again...", file)
+		_, err = in.runInTwrp("install-"+file, cmd)
+	}
+	return err
+}
+
+// installCmd returns the command that installs the zip at path in TWRP.
+func installCmd(path string) string {
+	return "twrp install " + android.ShellQuote(path)
+}
+
+// twrpJobTimeout is how long a zip install or script may run in TWRP.
+const twrpJobTimeout = 20 * time.Minute
+
+// runInTwrp runs cmd in TWRP as an android.DetachedJob, streaming its
+// output. A lost connection doesn't stop the job, so it waits for the device
+// to come back and carries on following it. It returns android.ErrJobLost if
+// TWRP restarted before the job finished.
+func (in *install) runInTwrp(name, cmd string) (*android.DetachedJob, error) {
+	job, err := in.adb.StartDetached(name, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(twrpJobTimeout)
+	for {
+		done, status, err := job.Poll(os.Stdout)
+		switch {
+		case err == nil && done && status == 0:
+			return job, nil
+		case err == nil && done:
+			return job, fmt.Errorf("%s failed with exit status %d", cmd, status)
+		case err == nil:
+		case isTransient(err):
+			in.echo("")
+			in.echo("Lost connection to your device, waiting for it to come back...")
+			if werr := in.adb.WaitUntil(android.DevicePresent, rebootTimeout); werr != nil {
+				return job, err
+			}
+		default:
+			return job, err
+		}
+
+		if time.Now().After(deadline) {
+			return job, fmt.Errorf("%s didn't finish within %s", cmd, twrpJobTimeout)
+		}
+		if err := in.ctxError(); err != nil {
+			return job, err
+		}
+		in.sleep(2 * time.Second)
+	}
+}
+
+// installBase boots TWRP and installs everything but the Nethunter
+// filesystem, which needs the OS to have booted once first.
+func (in *install) installBase() error {
+	d := in.device
+
+	// Boot into twrp
+	in.echo("Booting TWRP to flash Nethunter update zip.\n Swipe to allow system modification in TWRP and wait")
+	if err := in.bootTwrp(); err != nil {
+		return err
+	}
+
+	// Wait for TWRP
+	if !in.twrpReady {
+		in.opts.WaitForUser("Press enter when TWRP is fully loaded & ready")
+	}
+	if err := in.waitForTwrp(); err != nil {
+		return err
+	}
+	if err := in.checkCharging(); err != nil {
+		return err
+	}
+
+	// Start fresh
+	if err := in.step(StepWipe); err != nil {
+		return err
+	}
+	if !in.selected(StepWipe) {
+		in.skipStep(StepWipe)
+	} else if in.opts.Update {
+		in.echo("Skipping removal of previous installations (updating)")
+	} else if in.opts.NoWipe {
+		in.echo("Skipping removal of previous installations (--no-wipe)")
+	} else {
+		in.echo("Removing previous installations")
+		if err := in.wipe(in.preWipe()); err != nil {
+			return err
+		}
+		if err := in.formatDataIfNeeded(); err != nil {
+			return err
+		}
+	}
+
+	if in.selected(StepInstall) {
+		if err := in.runInstallCmds(d.Pre_install_cmds); err != nil {
+			return err
+		}
+	}
+
+	// Encrypted devices may not have /sdcard available in TWRP
+	if err := in.step(StepPush); err != nil {
+		return err
+	}
+	in.checkSdcardOrFallback()
+
+	if !in.selected(StepPush) {
+		in.skipStep(StepPush)
+	} else if err := in.pushZips(); err != nil {
+		return err
+	}
+
+	if err := in.step(StepInstall); err != nil {
+		return err
+	}
+	if !in.selected(StepInstall) {
+		in.skipStep(StepInstall)
+		return nil
+	}
+	return in.installPushed()
+}
+
+// pushZips copies the zips for the base install to the device.
+func (in *install) pushZips() error {
+	d := in.device
+
+	// Extras (firmware/etc) first, then the ROM and the filesystem with the
+	// app, which are installed in TWRP
+	type push struct{ name, file string }
+	var pushes []push
+	if d.extraZip() {
+		pushes = append(pushes, push{"extra zip (firmware/etc)", d.Extra_file})
+	}
+	pushes = append(pushes,
+		push{"NethunterOS zip", d.Nhos_file},
+		push{"Nethunter filesystem zip", d.Nhfs_file},
+	)
+	if in.gapps != nil {
+		pushes = append(pushes, push{"Google Apps zip", in.gapps.File})
+	}
+
+	for i, p := range pushes {
+		if err := in.ensureZip(p.file); err != nil {
+			return err
+		}
+		in.echo("[%d/%d] Transferring the %s to your device...", i+1, len(pushes), p.name)
+		if err := in.pushIfNeeded(p.file); err != nil {
+			return newError("Failed to push the "+p.name+" to device", ErrorAdb, err)
+		}
+	}
+	return nil
+}
+
+// installPushed installs the zips pushZips copied to the device and cleans
+// up after them.
+func (in *install) installPushed() error {
+	d := in.device
+
+	// Extras should be installed first (like Device firmware or baseband)
+	// Otherwise NHOS will fail
+	var zips []zipInstall
+	if d.extraZip() {
+		zips = append(zips, zipInstall{d.Extra_file, "Extras"})
+	}
+
+	// Start installer for ROM, Gapps, then Nethunter chroot & apps
+	zips = append(zips, zipInstall{d.Nhos_file, "NethunterOS"})
+	if in.gapps != nil {
+		zips = append(zips, zipInstall{in.gapps.File, in.gapps.assetName()})
+	} else {
+		in.echo("Skipping Gapps install")
+	}
+	if err := in.installZips(zips); err != nil {
+		return err
+	}
+
+	if err := in.waitForTwrpAdb(); err != nil {
+		return err
+	}
+	in.echo("Wiping your device without wiping /data/media...")
+	if err := in.wipe(d.Post_wipe); err != nil {
+		return err
+	}
+	return in.runInstallCmds(d.Post_install_cmds)
+}
+
+// installFilesystem boots TWRP again and installs the Nethunter filesystem.
+func (in *install) installFilesystem() error {
+	d := in.device
+
+	// Boot into twrp
+	in.echo("Booting TWRP to flash Nethunter update zip.\n Swipe to allow system modification in TWRP and wait")
+	if err := in.bootTwrp(); err != nil {
+		return err
+	}
+
+	// Wait for TWRP
+	if !in.twrpReady {
+		in.opts.WaitForUser("Press enter when TWRP is fully loaded & ready")
+	}
+	if err := in.waitForTwrp(); err != nil {
+		return err
+	}
+	if err := in.checkCharging(); err != nil {
+		return err
+	}
+
+	// The filesystem zip is gone if it had to be pushed to /tmp
+	if err := in.step(StepInstallFilesystem); err != nil {
+		return err
+	}
+	in.checkSdcardOrFallback()
+	if err := in.pushIfNeeded(d.Nhfs_file); err != nil {
+		return newError("Failed to push Nethunter update zip to device", ErrorAdb, err)
+	}
+
+	in.echo("Installing Nethunter filesystem, please keep your device connected...")
+	if err := in.installZip(d.Nhfs_file); err != nil {
+		return newError("Failed to flash Nethunter update zip", ErrorTWRP, err)
+	}
+	in.installed("Nethunter filesystem")
+	return nil
+}
@@ -0,0 +1,161 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package installer
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sync"
+
+	"../remote"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+func fileHash(path string, h hash.Hash) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fileMD5 returns the hex encoded md5 sum of the file at path.
+func fileMD5(path string) (string, error) {
+	return fileHash(path, md5.New())
+}
+
+// checkSize makes sure a's file has the size given in the config, if any,
+// which catches truncated downloads without a checksum.
+func checkSize(a Asset) error {
+	if a.Size <= 0 {
+		return nil
+	}
+	info, err := os.Stat(a.File)
+	if err != nil {
+		return err
+	}
+	if info.Size() != a.Size {
+		return fmt.Errorf("%s is %d bytes, expected %d", a.File, info.Size(), a.Size)
+	}
+	return nil
+}
+
+// hashWorkers bounds how many files verifyCached hashes at once, since
+// hashing big files is limited by the disk as much as by the CPU.
+const hashWorkers = 4
+
+// verifyCached checks the files of assets left over from earlier runs
+// against their known SHA-256 sums, hashing several at a time, and records
+// the sums of the good ones. Files without a known sum are left to be checked
+// after downloading. It returns the assets whose files don't match, with
+// why.
+func (in *install) verifyCached(assets []Asset) (bad []Asset, errs []error) {
+	type job struct {
+		a        Asset
+		expected string
+	}
+	var jobs []job
+	for _, a := range assets {
+		if _, ok := in.sums[a.File]; ok {
+			continue
+		}
+		if expected := in.expectedSum(a); expected != "" {
+			jobs = append(jobs, job{a, expected})
+		}
+	}
+
+	sums := make([]string, len(jobs))
+	hashErrs := make([]error, len(jobs))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < hashWorkers && w < len(jobs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				sums[i], hashErrs[i] = fileHash(jobs[i].a.File, sha256.New())
+			}
+		}()
+	}
+	for i := range jobs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for i, j := range jobs {
+		err := hashErrs[i]
+		if err == nil && sums[i] != j.expected {
+			err = fmt.Errorf("%s is corrupt (sha256 %s, expected %s)", j.a.File, sums[i], j.expected)
+		}
+		if err != nil {
+			bad = append(bad, j.a)
+			errs = append(errs, err)
+			continue
+		}
+		in.sums[j.a.File] = sums[i]
+	}
+	return bad, errs
+}
+
+// readKeyring reads the armored public keys used to sign release manifests.
+func readKeyring(path string) (openpgp.EntityList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return openpgp.ReadArmoredKeyRing(f)
+}
+
+// verifyManifest checks every asset that is listed in manifest against its
+// SHA-256 sum. sums holds the sums of files downloaded or already verified
+// this run, so only files that haven't been hashed yet are read again.
+// Assets missing from the manifest are returned so the caller can warn about
+// them.
+func verifyManifest(manifest remote.Manifest, assets []Asset, sums map[string]string) (unlisted []Asset, err error) {
+	for _, a := range assets {
+		expected, ok := manifest[a.File]
+		if !ok {
+			unlisted = append(unlisted, a)
+			continue
+		}
+
+		actual, ok := sums[a.File]
+		if !ok {
+			if err := remote.VerifyFile(a.File, expected); err != nil {
+				return unlisted, err
+			}
+			continue
+		}
+		if actual != expected {
+			return unlisted, fmt.Errorf("%s is corrupt (sha256 %s, expected %s)", a.File, actual, expected)
+		}
+	}
+	return unlisted, nil
+}
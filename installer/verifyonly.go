@@ -0,0 +1,103 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package installer
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"strconv"
+	"strings"
+
+	"../android"
+)
+
+// VerifyInstalled checks the Nethunter install on a device booted into
+// Android against its config without flashing anything, printing a line per
+// check. Checks that need a local copy of an image or root on the device are
+// skipped when those aren't there.
+func VerifyInstalled(ctx context.Context, nhDevices Devices, opts Options) error {
+	in := newInstall(ctx, Device{}, opts)
+	status, err := in.adb.Status()
+	if err != nil {
+		return newError("Failed to run adb", ErrorAdb, err)
+	}
+	if status != android.DeviceConnected {
+		return newError("No device found, boot your device into Android with USB debugging enabled", ErrorAdb, nil)
+	}
+
+	product, err := in.adb.GetProp("ro.product.device")
+	if err != nil {
+		return newError("Failed to identify your device", ErrorAdb, err)
+	}
+	in.device, err = FindDeviceConfig(nhDevices, android.DeviceIdentity{Product: product})
+	if err != nil {
+		return newError("No device config for "+product, ErrorNoDeviceConfig, err)
+	}
+	d := in.device
+
+	in.echo("Verifying the Nethunter install on your %s...", d.Common_name)
+	ok := true
+	if !d.bootOnly() {
+		version, _ := in.adb.GetProp(nethunterVersionProp)
+		ok = in.reportCheck("NethunterOS installed (version "+orUnknown(version)+")", version != "") && ok
+	}
+	if d.Android_sdk != 0 {
+		sdk, _ := in.adb.GetProp("ro.build.version.sdk")
+		level, _ := strconv.Atoi(sdk)
+		ok = in.reportCheck(androidVersion(d.Android_sdk)+" installed", level == d.Android_sdk) && ok
+	}
+	ok = in.checkApps() && ok
+	if d.bootOnly() {
+		ok = in.checkPartition("Nethunter kernel", "boot", d.Boot_file) && ok
+	} else if d.flashesTwrp() {
+		ok = in.checkPartition("TWRP Recovery", "recovery", d.Twrp_file) && ok
+	}
+
+	if !ok {
+		return newError("Your Nethunter install failed verification", ErrorVerify, nil)
+	}
+	in.echo("Your Nethunter install passed verification")
+	return nil
+}
+
+// checkPartition compares partition on the device with the local image it
+// was flashed from, hashing only as much of the partition as the image fills.
+// The check is skipped, passing, without the image or root on the device.
+func (in *install) checkPartition(name, partition, image string) bool {
+	info, err := os.Stat(image)
+	if err != nil {
+		in.echo("    [SKIP] %s matches %s (no local copy)", name, image)
+		return true
+	}
+	expected, err := fileHash(image, sha256.New())
+	if err != nil {
+		in.echo("    [SKIP] %s matches %s (%s)", name, image, err.Error())
+		return true
+	}
+
+	// A/B devices name their partitions by slot
+	block := "/dev/block/by-name/" + partition + "$(getprop ro.boot.slot_suffix)"
+	cmd := "head -c " + strconv.FormatInt(info.Size(), 10) + " " + block + " | sha256sum"
+	output, err := in.adb.ShellOutput("su -c " + android.ShellQuote(cmd))
+	fields := strings.Fields(output)
+	if err != nil || len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		in.echo("    [SKIP] %s matches %s (reading %s needs root)", name, image, partition)
+		return true
+	}
+	return in.reportCheck(name+" matches "+image, fields[0] == expected)
+}
@@ -0,0 +1,99 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package installer
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// splitVersion breaks a vendor version string like "HHZ20h" or
+// "MSM8996_1.2-3" into runs of digits and runs of letters, dropping
+// punctuation.
+func splitVersion(v string) []string {
+	var parts []string
+	var current []rune
+	flush := func() {
+		if len(current) > 0 {
+			parts = append(parts, string(current))
+			current = current[:0]
+		}
+	}
+
+	for _, r := range strings.ToLower(v) {
+		if !unicode.IsDigit(r) && !unicode.IsLetter(r) {
+			flush()
+			continue
+		}
+		if len(current) > 0 && unicode.IsDigit(current[0]) != unicode.IsDigit(r) {
+			flush()
+		}
+		current = append(current, r)
+	}
+	flush()
+	return parts
+}
+
+// androidVersions are the Android releases by API level.
+var androidVersions = map[int]string{
+	21: "5.0", 22: "5.1", 23: "6.0", 24: "7.0", 25: "7.1", 26: "8.0",
+	27: "8.1", 28: "9", 29: "10", 30: "11", 31: "12", 32: "12L", 33: "13",
+	34: "14", 35: "15", 36: "16",
+}
+
+// androidVersion returns the Android release with API level sdk.
+func androidVersion(sdk int) string {
+	if v, ok := androidVersions[sdk]; ok {
+		return "Android " + v
+	}
+	return "Android API " + strconv.Itoa(sdk)
+}
+
+// compareVersions compares two version strings, returning -1, 0 or 1 if a is
+// older than, the same as or newer than b. Runs of digits are compared as
+// numbers and anything else alphabetically, which copes with most vendor
+// formats without knowing them.
+func compareVersions(a, b string) int {
+	pa, pb := splitVersion(a), splitVersion(b)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		na, errA := strconv.Atoi(pa[i])
+		nb, errB := strconv.Atoi(pb[i])
+		switch {
+		case errA == nil && errB == nil:
+			if na != nb {
+				if na < nb {
+					return -1
+				}
+				return 1
+			}
+		case pa[i] != pb[i]:
+			if pa[i] < pb[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case len(pa) < len(pb):
+		return -1
+	case len(pa) > len(pb):
+		return 1
+	}
+	return 0
+}
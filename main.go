@@ -18,45 +18,35 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
 	"os"
+	"os/signal"
 	"path"
+	"path/filepath"
 	"runtime"
-	"time"
+	"strings"
 
 	"./android"
+	"./installer"
 	"./remote"
 
 	"github.com/dixonwille/wmenu"
 	"github.com/pdsouza/toolbox.go/ui"
-)
-
-const (
-	// Success exit codes.
-	SuccessBase = 1<<5 + iota
-	SuccessUserAbort
-	SuccessBootloaderUnlocked
-
-	Success = 0
-)
-
-const (
-	// Error exit codes.
-	ErrorBase = 1<<6 + iota
-	ErrorPrereqs
-	ErrorUserInput
-	ErrorUsbPerms
-	ErrorAdb
-	ErrorFastboot
-	ErrorRemote
-	ErrorTWRP
+	"golang.org/x/term"
 )
 
 var (
 	reader      = bufio.NewReader(os.Stdin)
 	progressBar = ui.ProgressBar{0, 10, ""}
+
+	// Progress bars redraw in place with \r, which turns into garbage when
+	// output is redirected to a file or another program.
+	stdoutIsTerminal = term.IsTerminal(int(os.Stdout.Fd()))
+	stdinIsTerminal  = term.IsTerminal(int(os.Stdin.Fd()))
 )
 
 func iEcho(format string, a ...interface{}) {
@@ -64,52 +54,343 @@ func iEcho(format string, a ...interface{}) {
 }
 
 func eEcho(msg string) {
+	setLastError(msg)
 	iEcho(msg)
 }
 
-func verifyAdbStatusOrAbort(adb *android.AdbClient) {
-	status, err := adb.Status()
+// parseSteps reads the --steps flag, printing the steps and exiting for
+// "list".
+func parseSteps(flagValue string) []string {
+	if flagValue == "" {
+		return nil
+	}
+	if flagValue == "list" {
+		for _, s := range installer.SelectableSteps() {
+			fmt.Printf("%-20s %s\n", s.Name, s.Description)
+		}
+		exit(installer.Success)
+	}
+
+	steps := strings.Split(flagValue, ",")
+	for i := range steps {
+		steps[i] = strings.TrimSpace(steps[i])
+	}
+	if err := installer.CheckSteps(steps); err != nil {
+		fail(installer.ErrorUserInput, "Invalid --steps: "+err.Error())
+	}
+	return steps
+}
+
+// sharedCacheDir returns the OS's cache directory for the installer, or ""
+// when there is none or downloads go to a directory of the user's choosing.
+func sharedCacheDir(explicitDir bool) string {
+	if explicitDir {
+		return ""
+	}
+	dir, err := os.UserCacheDir()
 	if err != nil {
-		eEcho("Failed to get adb status: " + err.Error())
-		exit(ErrorAdb)
+		return ""
+	}
+	return filepath.Join(dir, "nethunter-installer")
+}
+
+// headerFlags collects the values of a repeated header flag.
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(value string) error {
+	*h = append(*h, value)
+	return nil
+}
+
+// flagGiven reports whether the flag name was set on the command line.
+func flagGiven(name string) bool {
+	given := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			given = true
+		}
+	})
+	return given
+}
+
+// oneOf reports whether value is one of valid, for flags taking a fixed set
+// of values.
+func oneOf(value string, valid []string) bool {
+	for _, m := range valid {
+		if value == m {
+			return true
+		}
+	}
+	return false
+}
+
+// listDevices prints every device in the config along with the assets that
+// would be downloaded for it.
+func listDevices(nhDevices installer.Devices, asJSON bool) {
+	type listing struct {
+		CommonName  string            `json:"common_name"`
+		ProductName string            `json:"product_name"`
+		Variant     string            `json:"variant,omitempty"`
+		Assets      []installer.Asset `json:"assets"`
+	}
+
+	var listings []listing
+	for _, d := range nhDevices.Device {
+		listings = append(listings, listing{d.Common_name, d.Product_name, d.Variant, d.Assets()})
 	}
-	if status == android.NoDeviceFound || status == android.DeviceUnauthorized {
-		eEcho(MsgAdbIssue)
-		exit(ErrorAdb)
-	} else if status == android.NoUsbPerms {
-		eEcho(MsgFixPerms)
-		exit(ErrorUsbPerms)
+
+	if asJSON {
+		b, err := json.MarshalIndent(listings, "", "  ")
+		if err != nil {
+			fail(installer.ErrorUserInput, "Failed to encode device list: "+err.Error())
+		}
+		fmt.Println(string(b))
+		return
+	}
+
+	for _, l := range listings {
+		fmt.Printf("%s (%s)\n", l.CommonName, l.ProductName)
+		for _, a := range l.Assets {
+			fmt.Printf("    %-20s %s\n", a.Name+":", a.URL)
+		}
 	}
 }
 
-func verifyFastbootStatusOrAbort(fastboot *android.FastbootClient) {
-	status, err := fastboot.Status()
+// printAssetsManifest prints the files the device named name downloads.
+func printAssetsManifest(nhDevices installer.Devices, name string, asJSON bool) {
+	d, err := installer.FindDeviceByName(nhDevices, name)
 	if err != nil {
-		eEcho("Failed to get fastboot status: " + err.Error())
-		exit(ErrorFastboot)
+		fail(installer.ErrorNoDeviceConfig, fmt.Sprintf("No device named %q, see --list-devices", name))
+	}
+	entries, err := installer.AssetsManifest(context.Background(), d, installer.Options{
+		Mirrors:     nhDevices.Mirrors,
+		ManifestKey: nhDevices.Manifest_key,
+		Echo:        iEcho,
+	})
+	exitOnError(err)
+
+	if asJSON {
+		b, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			fail(installer.ErrorUserInput, "Failed to encode assets manifest: "+err.Error())
+		}
+		fmt.Println(string(b))
+		return
+	}
+
+	iEcho("Files downloaded for the %s:", d.Common_name)
+	for _, e := range entries {
+		iEcho("%s", e)
+	}
+}
+
+// welcomeDeviceLimit caps how many devices the welcome screen names, so a
+// growing config doesn't push the instructions off the screen.
+const welcomeDeviceLimit = 12
+
+// printDeviceNames prints the names of the supported devices in columns
+// fitted to the terminal, leaving the rest to --list-devices.
+func printDeviceNames(nhDevices installer.Devices) {
+	var names []string
+	for _, d := range nhDevices.Device {
+		names = append(names, d.Common_name)
+	}
+	shown := names
+	if len(shown) > welcomeDeviceLimit {
+		shown = shown[:welcomeDeviceLimit]
+	}
+
+	width := 0
+	for _, name := range shown {
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	width += 2
+	columns := (terminalColumns() - 4) / width
+	if columns < 1 {
+		columns = 1
+	}
+
+	iEcho("Supported devices (%d):", len(names))
+	line := "    "
+	for i, name := range shown {
+		line += fmt.Sprintf("%-*s", width, name)
+		if (i+1)%columns == 0 || i == len(shown)-1 {
+			iEcho("%s", strings.TrimRight(line, " "))
+			line = "    "
+		}
+	}
+	if more := len(names) - len(shown); more > 0 {
+		iEcho("    ...and %d more", more)
+	}
+	iEcho("Run the installer with --list-devices for the full list and what each device downloads.")
+}
+
+// printSummary prints what a successful install did, as a final JSON event
+// when asJSON is set.
+func printSummary(s installer.Summary, asJSON bool) {
+	if !asJSON {
+		iEcho("\n" + s.String())
+		return
 	}
-	if status == android.NoDeviceFound {
-		eEcho(MsgFastbootNoDeviceFound)
-		exit(ErrorFastboot)
-	} else if status == android.NoUsbPerms {
-		eEcho(MsgFixPerms)
-		exit(ErrorUsbPerms)
+
+	b, err := json.Marshal(struct {
+		Event string `json:"event"`
+		installer.Summary
+	}{"summary", s})
+	if err != nil {
+		eEcho("Failed to encode install summary: " + err.Error())
+		return
 	}
+	fmt.Println(string(b))
 }
 
 func progressCallback(percent float64) {
+	if !stdoutIsTerminal {
+		return
+	}
 	progressBar.Progress = percent
+	progressBar.Width = progressBarWidth()
 	fmt.Print("\r" + progressBar.Render())
 	if percent == 1.0 {
 		fmt.Println()
 	}
 }
 
+// Bounds on the progress bar width, and the room it leaves on the line for
+// the brackets and percentage around the bar.
+const (
+	minProgressWidth = 10
+	maxProgressWidth = 60
+	progressPadding  = 12
+)
+
+// terminalColumns returns the width of the terminal, or 80 when stdout isn't
+// one.
+func terminalColumns() int {
+	cols, _, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || cols <= 0 {
+		return 80
+	}
+	return cols
+}
+
+// progressBarWidth sizes the progress bar to the terminal, which may have
+// been resized since the last time it was drawn.
+func progressBarWidth() int {
+	width := terminalColumns() - progressPadding
+	if width < minProgressWidth {
+		return minProgressWidth
+	}
+	if width > maxProgressWidth {
+		return maxProgressWidth
+	}
+	return width
+}
+
 func waitForOpKey(msg string) {
 	fmt.Printf(msg)
 	bufio.NewReader(os.Stdin).ReadBytes('\n')
 }
 
+// errNoTerminal is returned by choose when there is no terminal to ask on.
+var errNoTerminal = errors.New("stdin is not a terminal, pass the choice as a flag instead")
+
+// confirm asks the user a yes or no question. Without a terminal to ask on
+// the answer is no, so nothing extra is done that the user didn't ask for with
+// a flag.
+func confirm(question string) (bool, error) {
+	if !stdinIsTerminal {
+		iEcho("%s no (stdin is not a terminal)", question)
+		return false, nil
+	}
+	yes := false
+	menu := wmenu.NewMenu(question)
+	menu.Action(func(opts []wmenu.Opt) error { yes = opts[0].ID == 0; return nil })
+	menu.IsYesNo(0)
+	err := menu.Run()
+	return yes, err
+}
+
+// choose asks the user to pick one of choices, defaulting to the first.
+// There is no safe default without a terminal, so it fails instead.
+func choose(question string, choices []string) (int, error) {
+	if !stdinIsTerminal {
+		return 0, errNoTerminal
+	}
+	chosen := 0
+	menu := wmenu.NewMenu(question)
+	menu.Action(func(opts []wmenu.Opt) error { chosen = opts[0].ID; return nil })
+	for i, c := range choices {
+		menu.Option(c, nil, i == 0, nil)
+	}
+	err := menu.Run()
+	return chosen, err
+}
+
+// doctor prints whether everything needed to install is in place and exits,
+// failing if anything isn't.
+func doctor(nhDevices installer.Devices) {
+	code := installer.Success
+	for _, r := range installer.Doctor(context.Background(), nhDevices, installer.Options{Mirrors: nhDevices.Mirrors}) {
+		result := "PASS"
+		if !r.OK {
+			result = "FAIL"
+			code = installer.ErrorPrereqs
+		}
+		iEcho("[%s] %-24s %s", result, r.Name, r.Detail)
+	}
+	exit(code)
+}
+
+// abortOnInterrupt cancels the install on the first Ctrl-C so it can stop
+// cleanly. A second Ctrl-C quits straight away.
+func abortOnInterrupt(cancel context.CancelFunc) {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		signal.Stop(interrupts)
+		iEcho("\nAborting the install, press Ctrl-C again to quit immediately...")
+		cancel()
+	}()
+}
+
+// exitOnError reports err and exits with the exit code it maps to.
+func exitOnError(err error) {
+	if err != nil {
+		finish(installer.ResultOf(err))
+	}
+}
+
+// fail reports msg and exits with code.
+func fail(code int, msg string) {
+	finish(installer.ResultOf(&installer.Error{Msg: msg, Code: code}))
+}
+
+// finish ends the installer with res. Failures are reported with a
+// diagnostic report, and the hook for the outcome is run.
+func finish(res installer.Result) {
+	if res.Failed() {
+		eEcho(res.Err.Error())
+		// nothing went wrong when the user cancelled or answered a prompt wrong
+		if res.Code != installer.ErrorUserInput {
+			if report, rerr := writeReport(res.Err); rerr == nil {
+				iEcho("A diagnostic report was saved to %s, please attach it when reporting the problem.", report)
+			} else {
+				eEcho("Failed to save a diagnostic report: " + rerr.Error())
+			}
+		}
+	}
+	runHook(res)
+	exit(res.Code)
+}
+
 func exit(code int) {
 	// When run by double-clicking the executable on windows, the command
 	// prompt will immediately exit upon program completion, making it hard for
@@ -123,8 +404,37 @@ func exit(code int) {
 	os.Exit(code)
 }
 
+// shippedConfig is the device config shipped next to the installer.
+const shippedConfig = "devices.toml"
+
+// checkConfigSum fails unless the config read from file has the SHA-256 sum
+// given with --config-sha. Without one, it warns when an unsigned config (the
+// shipped one or a --config file) doesn't match the one the installer was
+// built with.
+func checkConfigSum(file, expected string, signed bool) {
+	if expected != "" {
+		if err := remote.VerifyFile(file, strings.ToLower(expected)); err != nil {
+			fail(installer.ErrorUserInput, "The device config doesn't match --config-sha: "+err.Error())
+		}
+		return
+	}
+	if ConfigSum == "" || signed {
+		return
+	}
+	err := remote.VerifyFile(file, ConfigSum)
+	switch {
+	case err == nil:
+	case file == shippedConfig:
+		eEcho("Warning: " + shippedConfig + " differs from the one this installer was built with, make sure you trust its changes: " + err.Error())
+	default:
+		eEcho("Warning: " + file + " differs from the " + shippedConfig + " this installer was built with, make sure you trust it")
+	}
+}
+
 func main() {
-	nhDevices := readDevicesConfig()
+	defer handlePanic()
+
+	var err error
 
 	/*
 		Step 1 - Set path to binaries
@@ -139,352 +449,314 @@ func main() {
 	*/
 
 	var versionFlag = flag.Bool("version", false, "print the program version")
+	var noWipeFlag = flag.Bool("no-wipe", false, "skip wiping data/system before installing (dirty upgrade)")
+	var checkFlag = flag.Bool("check", false, "check the installer, device connection and download servers, then exit")
+	var configFlag = flag.String("config", "", "read the device configs from this .toml or .json file instead of the shipped devices.toml")
+	var configShaFlag = flag.String("config-sha", "", "abort unless the device config (devices.toml, --config or the --config-bundle file) has this SHA-256 sum")
+	var configBundleFlag = flag.String("config-bundle", "", "use the device configs from this signed config bundle")
+	var simulateFlag = flag.String("simulate-device", "", "pretend a device with this product name is connected, for testing device configs")
+	var requireChargingFlag = flag.Bool("require-charging", false, "only install while the device is plugged into power")
+	var confirmWipesFlag = flag.Bool("confirm-wipes", false, "wait for enter before each partition is wiped")
+	var assumeUnlockedFlag = flag.Bool("assume-unlocked", false, "skip the bootloader unlock check, for devices you know are unlocked")
+	var forceDownloadFlag = flag.Bool("force-download", false, "download every file again instead of using copies from earlier runs")
+	var downloadHeaders headerFlags
+	flag.Var(&downloadHeaders, "download-header", "send this \"Name: Value\" header with every download, can be repeated")
+	var preferIPv4Flag = flag.Bool("prefer-ipv4", false, "download over IPv4 when possible, for networks with broken IPv6")
+	var preferIPv6Flag = flag.Bool("prefer-ipv6", false, "download over IPv6 when possible")
+	var insecureFlag = flag.Bool("insecure", false, "don't verify the download servers' certificates (UNSAFE, for computers with a wrong clock)")
+	var verifyOnlyFlag = flag.Bool("verify-only", false, "check the Nethunter install on the connected device against its config without flashing anything, then exit")
+	var verifyInstallFlag = flag.Bool("verify-install", false, "check the Nethunter app and chroot are installed once the device boots")
+	var mirrorTestFlag = flag.Bool("mirror-test", false, "measure the speed of every mirror and download from the fastest")
+	var stepsFlag = flag.String("steps", "", "only run these comma separated steps, or \"list\" to print them")
+	var unlockOnlyFlag = flag.Bool("unlock-only", false, "unlock the bootloader and exit without downloading or installing anything")
+	var postHookFlag = flag.String("post-hook", "", "shell command to run when the install succeeds")
+	var failHookFlag = flag.String("fail-hook", "", "shell command to run when the install fails")
+	var listDevicesFlag = flag.Bool("list-devices", false, "print the supported devices and exit")
+	var assetsManifestFlag = flag.String("assets-manifest", "", "print every file (with URLs, size and sha256) the named device downloads and exit, for building --offline-dir bundles")
+	var jsonFlag = flag.Bool("json", false, "print machine readable JSON (with --list-devices, and for the install summary)")
+	var formatDataFlag = flag.Bool("format-data", false, "deprecated, same as --data-mode=format")
+	var dataModeFlag = flag.String("data-mode", installer.DataWipe, "what to do with the data partition before installing: wipe, format (erases internal storage) or keep")
+	var forceFlag = flag.Bool("force", false, "flash even if the device doesn't match its config")
+	var strictFlag = flag.Bool("strict", false, "abort instead of warning when the bundled adb or fastboot are too old")
+	var retriesFlag = flag.Int("retries", 2, "times to retry the TWRP boot and install sequence after a lost connection")
+	var keepDownloadsFlag = flag.Bool("keep-downloads", true, "keep downloaded files after a successful install")
+	var cleanDownloadsFlag = flag.Bool("clean-downloads", false, "remove downloaded files after a successful install")
+	var gappsFlag = flag.String("gapps", "", "Google Apps variant to install (e.g. nano, pico), or none")
+	var acceptTosFlag = flag.Bool("accept-tos", false, "accept the Google Apps terms of service without being asked")
+	var twrpImageFlag = flag.String("twrp-image", "", "flash and boot this local TWRP image instead of downloading the device's")
+	var slotFlag = flag.String("slot", installer.SlotCurrent, "slot to flash and boot on A/B devices: a, b, current or other")
+	var recoveryOnlyFlag = flag.Bool("flash-recovery-only", false, "only download and flash TWRP recovery, then exit")
+	var timeoutFlag = flag.Duration("timeout-overall", 0, "give up on the install after this long (e.g. 30m), 0 for no limit")
+	var orsFlag = flag.Bool("ors", false, "batch TWRP wipes and installs into OpenRecoveryScripts")
+	var noRebootFlag = flag.Bool("no-reboot", false, "leave the device in TWRP at the end instead of rebooting it")
+	var segmentsFlag = flag.Int("download-segments", 1, "download each file as this many concurrent byte ranges")
+	var offlineDirFlag = flag.String("offline-dir", "", "install from the files in this directory instead of downloading them")
+	var downloadDirFlag = flag.String("download-dir", "", "keep downloaded files in this directory instead of the installer dir and the shared download cache")
+	var statusAddrFlag = flag.String("status-addr", "", "serve install status as JSON on this address (e.g. :8080)")
 	flag.Parse()
-	if *versionFlag == true {
-		iEcho("Nethunter installer version %s %s/%s", Version, runtime.GOOS, runtime.GOARCH)
-		exit(Success)
+	if *configFlag != "" && *configBundleFlag != "" {
+		fail(installer.ErrorUserInput, "--config can't be used with --config-bundle")
 	}
-
-	myPath, err := os.Executable()
-	if err != nil {
-		panic(err)
-	}
-
-	// include any bundled binaries in PATH
-	err = os.Setenv("PATH", path.Dir(myPath)+":"+os.Getenv("PATH"))
-	if err != nil {
-		eEcho("Failed to set PATH to include installer tools: " + err.Error())
-		exit(ErrorPrereqs)
+	configFile := shippedConfig
+	if *configFlag != "" {
+		configFile = *configFlag
 	}
-
-	// try to use the installer dir as the workdir to make sure any temporary
-	// files or downloaded dependencies are isolated to the installer dir
-	if err = os.Chdir(path.Dir(myPath)); err != nil {
-		eEcho("Warning: failed to change working directory")
+	nhDevices, err := installer.ReadDevicesConfig(configFile)
+	if err != nil && *configFlag != "" {
+		fail(installer.ErrorUserInput, "Failed to read --config: "+err.Error())
+	} else if err != nil {
+		eEcho("ERROR READING TOML: " + err.Error())
 	}
-
-	iEcho(MsgWelcome)
-	// (We can remove this later)
-	eEcho("The installer supports the following devices: ")
-	for _, d := range nhDevices.Device {
-		fmt.Printf("    - %s (%s)\n", d.Common_name, d.Product_name)
+	if *configBundleFlag != "" {
+		bundled, err := installer.LoadBundle(*configBundleFlag, nhDevices.Manifest_key)
+		if err != nil {
+			eEcho("Warning: ignoring config bundle, using the shipped config: " + err.Error())
+		} else {
+			nhDevices = bundled
+			configFile = *configBundleFlag
+		}
 	}
-	fmt.Print("\nAre you ready to install Nethunter? (yes/no): ")
-	responseBytes, _, err := reader.ReadLine()
-	if err != nil {
-		iEcho("Failed to read input: ", err.Error())
-		exit(ErrorUserInput)
+	checkConfigSum(configFile, *configShaFlag, configFile == *configBundleFlag)
+	if *versionFlag == true {
+		iEcho("Nethunter installer version %s %s/%s", Version, runtime.GOOS, runtime.GOARCH)
+		exit(installer.Success)
 	}
-
-	if "yes" != string(responseBytes) {
-		iEcho("")
-		iEcho("Aborting installation.")
-		exit(SuccessUserAbort)
+	if *listDevicesFlag {
+		listDevices(nhDevices, *jsonFlag)
+		exit(installer.Success)
 	}
-
-	iEcho("")
-	iEcho("Verifying installer tools...")
-	adb := android.NewAdbClient()
-	if _, err := adb.Status(); err != nil {
-		eEcho("Failed to run adb: " + err.Error())
-		eEcho(MsgIncompleteZip)
-		exit(ErrorPrereqs)
+	if *assetsManifestFlag != "" {
+		printAssetsManifest(nhDevices, *assetsManifestFlag, *jsonFlag)
+		exit(installer.Success)
 	}
-
-	fastboot := android.NewFastbootClient()
-	if _, err := fastboot.Status(); err != nil {
-		eEcho("Failed to run fastboot: " + err.Error())
-		eEcho(MsgIncompleteZip)
-		exit(ErrorPrereqs)
+	steps := parseSteps(*stepsFlag)
+	postHook, failHook = *postHookFlag, *failHookFlag
+	if *unlockOnlyFlag && (steps != nil || *assumeUnlockedFlag) {
+		fail(installer.ErrorUserInput, "--unlock-only can't be used with --steps or --assume-unlocked")
 	}
 
-	iEcho("Checking USB permissions...")
-	status, _ := fastboot.Status()
-	if status == android.NoDeviceFound {
-		// We are in ADB mode (normal boot or recovery).
-
-		verifyAdbStatusOrAbort(&adb)
-
-		iEcho("Rebooting your device into bootloader...")
-		err = adb.Reboot("bootloader")
-		if err != nil {
-			eEcho("Failed to reboot into bootloader: " + err.Error())
-			exit(ErrorAdb)
-		}
-
-		time.Sleep(7000 * time.Millisecond)
-
-		if status, err = fastboot.Status(); err != nil || status == android.NoDeviceFound {
-			eEcho("Failed to reboot device into bootloader!")
-			exit(ErrorAdb)
-		}
+	dataMode := *dataModeFlag
+	if !oneOf(dataMode, installer.DataModes) {
+		fail(installer.ErrorUserInput, fmt.Sprintf("Unknown --data-mode %q, expected one of: %s", dataMode, strings.Join(installer.DataModes, ", ")))
 	}
-
-	// We are in fastboot mode (the bootloader).
-
-	verifyFastbootStatusOrAbort(&fastboot)
-
-	iEcho("Identifying your device...")
-	productName, err := fastboot.GetProduct()
-
-	// OnePlus uses the same board name for every device.  Need to let user select
-	if productName == "QC_Reference_Phone" {
-		menu := wmenu.NewMenu("Detected OnePlus device.  Select which device: ")
-		menu.Action(func(opts []wmenu.Opt) error { productName = opts[0].Text; return nil })
-		menu.Option("OnePlus 5", nil, true, nil)
-		menu.Option("OnePlus 1", nil, false, nil)
-		err := menu.Run()
-		if err != nil {
-			log.Fatal(err)
+	dataModeGiven := flagGiven("data-mode")
+	if *formatDataFlag {
+		if dataModeGiven && dataMode != installer.DataFormat {
+			fail(installer.ErrorUserInput, "--format-data can't be used with --data-mode="+dataMode)
 		}
+		eEcho("Warning: --format-data is deprecated, use --data-mode=format")
+		dataMode, dataModeGiven = installer.DataFormat, true
 	}
-
-	if err != nil {
-		eEcho("Failed to get device product info: " + err.Error())
-		exit(ErrorFastboot)
-	}
-	currDevice := findDeviceConfig(nhDevices, productName)
-
-	// Check that we have the device config in the file
-
-	if currDevice.Common_name != "" {
-		fmt.Printf("Device and config found, using %s (%s) configuration and endpoints\n", currDevice.Common_name, currDevice.Product_name)
-	} else {
-		eEcho("Device config not found! Bye.")
-		exit(1)
+	if *noWipeFlag && dataModeGiven && dataMode != installer.DataKeep {
+		fail(installer.ErrorUserInput, "--no-wipe skips wiping, so it can't be used with --data-mode="+dataMode)
 	}
-
-	waitForOpKey("Press enter to continue with bootloader unlock check. Unlocking will wipe device if first time and will require restart.") // not sure about the sentence here
-
-	unlocked, err := fastboot.Unlocked()
-	if err != nil {
-		iEcho("Warning: unable to determine bootloader lock state: " + err.Error())
+	if !oneOf(*slotFlag, installer.Slots) {
+		fail(installer.ErrorUserInput, fmt.Sprintf("Unknown --slot %q, expected one of: %s", *slotFlag, strings.Join(installer.Slots, ", ")))
 	}
 
-	if !unlocked {
-		iEcho("Unlocking bootloader, you will need to confirm this on your device...")
-		err = fastboot.Unlock()
-		if err != nil {
-			eEcho("Failed to unlock bootloader: " + err.Error())
-			exit(ErrorFastboot)
-		}
-		fastboot.Reboot()
-		iEcho(MsgUnlockSuccess)
-		exit(SuccessBootloaderUnlocked)
+	// resolve the offline dir before changing the working directory below
+	offlineDir := *offlineDirFlag
+	if offlineDir != "" && *forceDownloadFlag {
+		fail(installer.ErrorUserInput, "--force-download can't be used with --offline-dir")
 	}
-
-	// Check if there is any other extra files we need to get
-	if currDevice.Extra_file != "" && currDevice.Extra_url != "" {
-		if _, err := os.Stat(currDevice.Extra_file); os.IsNotExist(err) { // If file missing, download
-			remote.DownloadURL(currDevice.Extra_url)
+	if offlineDir != "" {
+		if offlineDir, err = filepath.Abs(offlineDir); err != nil {
+			fail(installer.ErrorUserInput, "Failed to find offline dir: "+err.Error())
 		}
 	}
-
-	// Request nethunter OS
-	if _, err := os.Stat(currDevice.Nhos_file); os.IsNotExist(err) { // If file missing, download
-		remote.DownloadURL(currDevice.Nhos_url)
+	downloadDir := *downloadDirFlag
+	if downloadDir != "" && offlineDir != "" {
+		fail(installer.ErrorUserInput, "--download-dir can't be used with --offline-dir")
 	}
-
-	// Request nethunter generic fileysstem
-	if _, err := os.Stat(currDevice.Nhfs_file); os.IsNotExist(err) { // If file missing, download
-		remote.DownloadURL(currDevice.Nhfs_url)
-	}
-
-	// Request gapps
-	if _, err := os.Stat(currDevice.Gapps_file); os.IsNotExist(err) { // If file missing, download
-		remote.DownloadURL(currDevice.Gapps_url)
-	}
-
-	// Download TWRP
-	if _, err := os.Stat(currDevice.Twrp_file); os.IsNotExist(err) { // If file missing, download
-		remote.DownloadURL(currDevice.Twrp_url)
+	if downloadDir != "" {
+		if downloadDir, err = filepath.Abs(downloadDir); err != nil {
+			fail(installer.ErrorUserInput, "Failed to find download dir: "+err.Error())
+		}
 	}
 
-	waitForOpKey("Press enter to start the installation")
-
-	// Flash TWRP recovery
-	iEcho("Starting TWRP flash")
-	err = fastboot.FlashRecovery(currDevice.Twrp_file)
-	if err != nil {
-		eEcho("Failed to flash TWRP Recovery: " + err.Error())
-		exit(ErrorTWRP)
+	twrpImage := *twrpImageFlag
+	if twrpImage != "" {
+		if twrpImage, err = filepath.Abs(twrpImage); err != nil {
+			fail(installer.ErrorUserInput, "Failed to find TWRP image: "+err.Error())
+		}
 	}
 
-	// Boot into twrp
-	iEcho("Booting TWRP to flash Nethunter update zip.\n Swipe to allow system modification in TWRP and wait")
-	err = fastboot.Boot(currDevice.Twrp_file)
+	myPath, err := os.Executable()
 	if err != nil {
-		eEcho("Failed to boot TWRP: " + err.Error())
-		exit(ErrorTWRP)
+		panic(err)
 	}
 
-	// Wait for TWRP
-	waitForOpKey("Press enter when TWRP is fully loaded & ready")
-
-	// Start fresh
-	iEcho("Removing previous installations")
-	time.Sleep(1000 * time.Millisecond)
-	err = adb.Shell("twrp wipe dalvik")
+	// include any bundled binaries in PATH
+	err = os.Setenv("PATH", path.Dir(myPath)+":"+os.Getenv("PATH"))
 	if err != nil {
-		eEcho("Failed to wipe dalvik: " + err.Error())
-		exit(ErrorTWRP)
+		fail(installer.ErrorPrereqs, "Failed to set PATH to include installer tools: "+err.Error())
 	}
 
-	iEcho("Removing previous /data")
-	time.Sleep(1000 * time.Millisecond)
-	err = adb.Shell("twrp wipe data")
-	if err != nil {
-		eEcho("Failed to wipe data: " + err.Error())
-		exit(ErrorTWRP)
+	// try to use the installer dir as the workdir to make sure any temporary
+	// files or downloaded dependencies are isolated to the installer dir
+	if err = os.Chdir(path.Dir(myPath)); err != nil {
+		eEcho("Warning: failed to change working directory")
 	}
 
-	iEcho("Removing previous /system")
-	time.Sleep(1000 * time.Millisecond)
-	err = adb.Shell("twrp wipe system")
-	if err != nil {
-		eEcho("Failed to wipe system: " + err.Error())
-		exit(ErrorTWRP)
+	// assets are looked up by their file name in the working directory
+	if offlineDir != "" {
+		if err = os.Chdir(offlineDir); err != nil {
+			fail(installer.ErrorUserInput, "Failed to use offline dir: "+err.Error())
+		}
+		iEcho("Installing offline from %s, nothing will be downloaded.", offlineDir)
 	}
-
-	// Transfer any extra files we need to flash
-	if currDevice.Extra_file != "" {
-		iEcho("Transferring extra zip (firmware/etc) to your device...")
-		if err = adb.PushFg(currDevice.Extra_file, "/sdcard"); err != nil {
-			eEcho("Failed to push extra update zip to device: " + err.Error())
-			exit(ErrorAdb)
+	if downloadDir != "" {
+		err = os.MkdirAll(downloadDir, 0755)
+		if err == nil {
+			err = os.Chdir(downloadDir)
+		}
+		if err != nil {
+			fail(installer.ErrorUserInput, "Failed to use download dir: "+err.Error())
 		}
 	}
 
-	// Transfer ROM to sdcard then install in TWRP
-	iEcho("Transferring the NethunterOS zip to your device...")
-	if err = adb.PushFg(currDevice.Nhos_file, "/sdcard"); err != nil {
-		eEcho("Failed to push NethunterOS update zip to device: " + err.Error())
-		exit(ErrorAdb)
+	if !stdoutIsTerminal {
+		iEcho("Output is not a terminal, showing plain progress.")
+		remote.InPlaceProgress = false
 	}
 
-	// Transfer filesystem with app to sdcard then install
-	iEcho("Transferring the Nethunter filesystem zip to your device...")
-	if err = adb.PushFg(currDevice.Nhfs_file, "/sdcard"); err != nil {
-		eEcho("Failed to push Nethunter update zip to device: " + err.Error())
-		exit(ErrorAdb)
+	// menus can't be shown without a terminal, so their flags decide
+	gapps := *gappsFlag
+	if !stdinIsTerminal && gapps == "" {
+		iEcho("Input is not a terminal, skipping Google Apps (pass --gapps to install them).")
+		gapps = "none"
 	}
 
-	// Transfer filesystem with app to sdcard then install
-	iEcho("Transferring the Google Apps zip to your device...")
-	if err = adb.PushFg(currDevice.Gapps_file, "/sdcard"); err != nil {
-		eEcho("Failed to push Google Apps zip to device: " + err.Error())
-		exit(ErrorAdb)
+	if *segmentsFlag > 1 {
+		remote.Segments = *segmentsFlag
 	}
 
-	// Extras should be installed first (like Device firmware or baseband)
-	// Otherwise NHOS will fail
-	if currDevice.Extra_file != "" {
-		iEcho("Installing extra zip (firmware/baseband/etc) please keep your device connected...")
-		err = adb.Shell("twrp install /sdcard/" + currDevice.Extra_file)
-		if err != nil {
-			eEcho("Failed to flash extra update zip: " + err.Error())
-			exit(ErrorTWRP)
-		}
+	if *preferIPv4Flag && *preferIPv6Flag {
+		fail(installer.ErrorUserInput, "--prefer-ipv4 can't be used with --prefer-ipv6")
+	} else if *preferIPv4Flag {
+		remote.PreferFamily("tcp4")
+	} else if *preferIPv6Flag {
+		remote.PreferFamily("tcp6")
 	}
 
-	// Start installer for ROM, Gapps, then Nethunter chroot & apps
-	iEcho("Installing NethunterOS please keep your device connected...")
-	err = adb.Shell("twrp install /sdcard/" + currDevice.Nhos_file)
-	if err != nil {
-		eEcho("Failed to flash Nethunter update zip: " + err.Error())
-		exit(ErrorTWRP)
-	}
-
-	// Install gapps?
-	actFunc := func(opts []wmenu.Opt) error {
-		if opts[0].ID == 0 {
-			iEcho("Installing Gapps...")
-			err = adb.Shell("twrp install /sdcard/" + currDevice.Gapps_file)
-			if err != nil {
-				eEcho("Failed to flash Google Apps: " + err.Error())
-				exit(ErrorTWRP)
-			}
+	for _, h := range downloadHeaders {
+		if err := remote.AddHeader(h); err != nil {
+			fail(installer.ErrorUserInput, "Invalid --download-header: "+err.Error())
 		}
-		if opts[0].ID == 1 {
-			fmt.Println("Skipping Gapps install")
-		}
-		return nil
 	}
-
-	menu := wmenu.NewMenu("Install Gapps?") // The yes or no question
-	menu.Action(actFunc)
-	menu.IsYesNo(0)
-	err = menu.Run()
-	if err != nil {
-		log.Fatal(err)
+	for _, h := range remote.RedactedHeaders() {
+		iEcho("Sending %s with every download", h)
 	}
 
-	// Pause a bit after install or TWRP gets confused
-	// is this allways enought?
-	time.Sleep(10000 * time.Millisecond)
-	iEcho("Wiping your device without wiping /data/media...")
-	err = adb.Shell("twrp wipe cache")
-	if err != nil {
-		eEcho("Failed to wipe cache: " + err.Error())
-		exit(ErrorTWRP)
-	}
-	time.Sleep(1000 * time.Millisecond)
-	err = adb.Shell("twrp wipe dalvik")
-	if err != nil {
-		eEcho("Failed to wipe dalvik: " + err.Error())
-		exit(ErrorTWRP)
+	if *insecureFlag {
+		iEcho("WARNING: certificate checks are turned off (--insecure), downloads can be tampered with!")
+		remote.Insecure()
 	}
 
-	iEcho(MsgSuccess)
-	err = adb.Reboot("")
-	if err != nil {
-		eEcho("Failed to reboot: " + err.Error())
-		iEcho("\nPlease reboot your device manually by going to Reboot > System > Do Not Install")
-		exit(ErrorAdb)
+	if *simulateFlag != "" {
+		iEcho("Simulating a %s, no real device will be touched.", *simulateFlag)
+		android.Simulate(*simulateFlag)
 	}
-	// Wait for user to select install form usb option
-	iEcho(MsgReenable)
-	waitForOpKey("Press enter when ADB is reenabled")
 
-	verifyAdbStatusOrAbort(&adb)
-
-	iEcho("Rebooting your device into bootloader...")
-	err = adb.Reboot("bootloader")
-	if err != nil {
-		eEcho("Failed to reboot into bootloader: " + err.Error())
-		exit(ErrorAdb)
+	// after the remote and simulate setup, so the checks see what an install would
+	if *checkFlag {
+		doctor(nhDevices)
 	}
 
-	time.Sleep(30000 * time.Millisecond) // 30 seconds // maybe add waitForOpKey here also?
-
-	// Boot into twrp
-	iEcho("Booting TWRP to flash Nethunter update zip.\n Swipe to allow system modification in TWRP and wait")
-	err = fastboot.Boot(currDevice.Twrp_file)
-	if err != nil {
-		eEcho("Failed to boot TWRP: " + err.Error())
-		exit(ErrorTWRP)
+	if *statusAddrFlag != "" {
+		serveStatus(*statusAddrFlag)
 	}
 
-	// Wait for TWRP
-	waitForOpKey("Press enter when TWRP is fully loaded & ready")
+	if *verifyOnlyFlag {
+		exitOnError(installer.VerifyInstalled(context.Background(), nhDevices, installer.Options{Echo: iEcho}))
+		exit(installer.Success)
+	}
 
-	time.Sleep(20000 * time.Millisecond) // maybe add waitForOpKey here also?
-	iEcho("Installing Nethunter filesystem, please keep your device connected...")
-	err = adb.Shell("twrp install /sdcard/" + currDevice.Nhfs_file)
+	iEcho(MsgWelcome)
+	if *noWipeFlag {
+		iEcho(MsgNoWipe)
+	} else if dataMode == installer.DataFormat {
+		iEcho(MsgDataModeFormat)
+	}
+	printDeviceNames(nhDevices)
+	fmt.Print("\nAre you ready to install Nethunter? (yes/no): ")
+	responseBytes, _, err := reader.ReadLine()
 	if err != nil {
-		eEcho("Failed to flash Nethunter update zip: " + err.Error())
-		exit(ErrorTWRP)
+		fail(installer.ErrorUserInput, "Failed to read input: "+err.Error())
 	}
 
-	time.Sleep(30000 * time.Millisecond) // 30 seconds // maybe add waitForOpKey here also?
-
-	iEcho(MsgFinished)
-	err = adb.Reboot("")
-	if err != nil {
-		eEcho("Failed to reboot: " + err.Error())
-		iEcho("\nPlease reboot your device manually by going to Reboot > System > Do Not Install")
-		exit(ErrorAdb)
+	if "yes" != string(responseBytes) {
+		iEcho("")
+		iEcho("Aborting installation.")
+		exit(installer.SuccessUserAbort)
+	}
+
+	opts := installer.Options{
+		NoWipe:          *noWipeFlag,
+		RequireCharging: *requireChargingFlag,
+		DataMode:        dataMode,
+		Force:           *forceFlag,
+		Strict:          *strictFlag,
+		Retries:         *retriesFlag,
+		CleanDownloads:  *cleanDownloadsFlag || !*keepDownloadsFlag,
+		RecoveryOnly:    *recoveryOnlyFlag,
+		TwrpImage:       twrpImage,
+		Slot:            *slotFlag,
+		NoReboot:        *noRebootFlag,
+		ORS:             *orsFlag,
+		AssumeUnlocked:  *assumeUnlockedFlag,
+		ConfirmWipes:    *confirmWipesFlag && stdinIsTerminal,
+		ForceDownload:   *forceDownloadFlag,
+		CacheDir:        sharedCacheDir(offlineDir != "" || downloadDir != ""),
+		VerifyInstall:   *verifyInstallFlag,
+		MirrorTest:      *mirrorTestFlag,
+		Steps:           steps,
+		UnlockOnly:      *unlockOnlyFlag,
+		Offline:         offlineDir != "",
+		Gapps:           gapps,
+		HideGapps:       nhDevices.Hide_gapps,
+		AcceptTos:       *acceptTosFlag,
+		ManifestKey:     nhDevices.Manifest_key,
+		Mirrors:         nhDevices.Mirrors,
+		PlainProgress:   !stdoutIsTerminal,
+
+		Echo:        iEcho,
+		WaitForUser: waitForOpKey,
+		Confirm:     confirm,
+		Choose:      choose,
+		OnStep:      setStep,
+		OnSummary:   func(s installer.Summary) { printSummary(s, *jsonFlag) },
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	abortOnInterrupt(cancel)
+	if *timeoutFlag > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeoutFlag)
+		defer cancel()
+	}
+
+	// Updating an existing install doesn't need the device wiped
+	opts.InstalledSDK = installer.InstalledSDK(ctx, opts)
+	if version := installer.InstalledVersion(ctx, opts); version != "" {
+		iEcho("Found Nethunter %s already installed on your device.", version)
+		update, err := confirm("Update it, keeping your data?")
+		if err != nil {
+			fail(installer.ErrorUserInput, "Failed to read input: "+err.Error())
+		}
+		opts.Update = update
+	}
+	exitOnError(installer.CheckCharging(ctx, opts))
+	currDevice, err := installer.Identify(ctx, nhDevices, opts)
+	exitOnError(err)
+	reportDevice = &currDevice
+	// the device is in the bootloader once identified
+	fastboot := android.NewFastbootClient()
+	if id, err := fastboot.GetIdentity(); err == nil {
+		hookSerial = id.Serialno
 	}
+	exitOnError(installer.Run(ctx, currDevice, opts))
 
-	exit(Success)
+	finish(installer.ResultOf(nil))
 }
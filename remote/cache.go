@@ -0,0 +1,110 @@
+package remote
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// validators are what a server said identifies the version of a file it
+// sent, kept next to the file in file + ".validators" so the next run can ask
+// whether it changed instead of downloading it again.
+type validators struct {
+	URL          string
+	ETag         string
+	LastModified string
+}
+
+func validatorsFile(file string) string {
+	return file + ".validators"
+}
+
+// HasValidators reports whether file was downloaded from a server that can
+// tell us if it changed since.
+func HasValidators(file string) bool {
+	_, err := os.Stat(validatorsFile(file))
+	return err == nil
+}
+
+// ForgetValidators removes the validators saved for file, if any.
+func ForgetValidators(file string) {
+	os.Remove(validatorsFile(file))
+}
+
+// Discard removes file along with any partial download of it and its
+// validators, so the next Download of it starts from scratch.
+func Discard(file string) {
+	os.Remove(file)
+	os.Remove(partPath(file))
+	ForgetValidators(file)
+}
+
+// saveValidators records the validators in resp for file, or forgets any
+// old ones if the server sent none.
+func saveValidators(file, dlLink string, resp *http.Response) error {
+	v := validators{
+		URL:          dlLink,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	if v.ETag == "" && v.LastModified == "" {
+		err := os.Remove(validatorsFile(file))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	content := "URL: " + v.URL + "\nETag: " + v.ETag + "\nLast-Modified: " + v.LastModified + "\n"
+	return ioutil.WriteFile(validatorsFile(file), []byte(content), 0644)
+}
+
+// readValidators returns the validators saved for file.
+func readValidators(file string) (validators, error) {
+	var v validators
+	f, err := os.Open(validatorsFile(file))
+	if err != nil {
+		return v, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ": ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "URL":
+			v.URL = fields[1]
+		case "ETag":
+			v.ETag = fields[1]
+		case "Last-Modified":
+			v.LastModified = fields[1]
+		}
+	}
+	return v, scanner.Err()
+}
+
+// setConditional makes req only fetch dlLink if it changed since it was
+// saved to file. It reports whether it did, which it can't when there is no
+// file or it came from a different URL.
+func setConditional(req *http.Request, dlLink, file string) bool {
+	if _, err := os.Stat(file); err != nil {
+		return false
+	}
+	v, err := readValidators(file)
+	if err != nil || v.URL != dlLink {
+		return false
+	}
+
+	if v.ETag != "" {
+		req.Header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		req.Header.Set("If-Modified-Since", v.LastModified)
+	}
+	return v.ETag != "" || v.LastModified != ""
+}
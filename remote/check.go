@@ -0,0 +1,98 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CheckURL makes sure dlLink can be downloaded without fetching it. Servers
+// that don't support HEAD are asked for the first byte instead.
+func CheckURL(ctx context.Context, dlLink string) error {
+	_, err := head(ctx, dlLink)
+	return err
+}
+
+var (
+	sizeCacheMu sync.Mutex
+	sizeCache   = make(map[string]int64)
+)
+
+// AssetSize returns the size of the file at dlLink as served, so before any
+// decompression, without fetching it. The size is -1 if the server doesn't
+// say, e.g. for chunked responses. Sizes are cached for the rest of the run.
+func AssetSize(ctx context.Context, dlLink string) (int64, error) {
+	sizeCacheMu.Lock()
+	size, ok := sizeCache[dlLink]
+	sizeCacheMu.Unlock()
+	if ok {
+		return size, nil
+	}
+
+	resp, err := head(ctx, dlLink)
+	if err != nil {
+		return -1, err
+	}
+
+	size = responseSize(resp)
+	sizeCacheMu.Lock()
+	sizeCache[dlLink] = size
+	sizeCacheMu.Unlock()
+	return size, nil
+}
+
+// responseSize returns the size of the file behind a response from head, or
+// -1 if the server didn't say.
+func responseSize(resp *http.Response) int64 {
+	if resp.StatusCode != http.StatusPartialContent {
+		return resp.ContentLength
+	}
+
+	// the first byte was asked for, the total is in "bytes 0-0/<size>"
+	cr := resp.Header.Get("Content-Range")
+	if i := strings.LastIndex(cr, "/"); i >= 0 {
+		if n, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+			return n
+		}
+	}
+	return -1
+}
+
+// head asks for dlLink's headers, falling back to asking for the first byte
+// from servers that don't support HEAD.
+func head(ctx context.Context, dlLink string) (*http.Response, error) {
+	client := &http.Client{Transport: transport, Timeout: 30 * time.Second}
+
+	resp, err := doCheck(ctx, client, "HEAD", dlLink)
+	if err == nil && resp.StatusCode == http.StatusMethodNotAllowed {
+		resp, err = doCheck(ctx, client, "GET", dlLink)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return resp, nil
+}
+
+func doCheck(ctx context.Context, client *http.Client, method, dlLink string) (*http.Response, error) {
+	req, err := newRequest(ctx, method, dlLink)
+	if err != nil {
+		return nil, err
+	}
+	if method == "GET" {
+		req.Header.Set("Range", "bytes=0-0")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
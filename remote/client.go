@@ -0,0 +1,33 @@
+package remote
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// dialer opens every connection, unless PreferFamily replaces it.
+var dialer = &net.Dialer{
+	Timeout:   30 * time.Second,
+	KeepAlive: 30 * time.Second,
+}
+
+// transport carries every request, so connections to a server are kept
+// alive and reused across assets, mirrors and download segments. Options
+// that change how downloads connect, like Insecure and PreferFamily, are set
+// on it. Proxies are picked up from the environment.
+var transport = &http.Transport{
+	Proxy:                 http.ProxyFromEnvironment,
+	DialContext:           dialer.DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          32,
+	MaxIdleConnsPerHost:   16,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   15 * time.Second,
+	ResponseHeaderTimeout: time.Minute,
+	ExpectContinueTimeout: time.Second,
+}
+
+// client sends every request. Downloads can take as long as they need, so
+// only requests that should be quick set a timeout of their own.
+var client = &http.Client{Transport: transport}
@@ -1,51 +1,268 @@
 package remote
 
 import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"hash"
+	"io"
+	"net/http"
 	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
-	"github.com/cavaliercoder/grab"
+	"github.com/ulikunitz/xz"
 )
 
-func DownloadURL(dlLink string) {
-	// create client
-	client := grab.NewClient()
-	client.UserAgent = "Mozilla/5.0 (Windows NT 6.1; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/40.0.2214.85 Safari/537.36"
-	req, _ := grab.NewRequest(".", dlLink)
+// UserAgent is sent with every request since some mirrors refuse unknown
+// clients.
+const UserAgent = "Mozilla/5.0 (Windows NT 6.1; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/40.0.2214.85 Safari/537.36"
 
-	// Referrer needs to be set for TWRP
-	req.HTTPRequest.Header.Set("Referer", dlLink)
+// WorkDir is where partial downloads are kept, or "" to keep them next to
+// the file being downloaded. Giving each device its own lets installs on
+// several devices share a download directory without clobbering each other.
+var WorkDir string
+
+// partPath is where dst is downloaded to until it is complete.
+func partPath(dst string) string {
+	if WorkDir == "" {
+		return dst + ".part"
+	}
+	return filepath.Join(WorkDir, filepath.Base(dst)+".part")
+}
+
+// IsCompressed reports whether dlLink points to a compressed file that
+// Download decompresses on the fly.
+func IsCompressed(dlLink string) bool {
+	switch path.Ext(dlLink) {
+	case ".gz", ".xz":
+		return true
+	}
+	return false
+}
+
+// countingReader counts the bytes read through it so progress can be reported
+// from another goroutine.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+func (c *countingReader) count() int64 {
+	return atomic.LoadInt64(&c.n)
+}
+
+// Download fetches dlLink into dst and returns the hex encoded SHA-256 sum of
+// dst, computed while downloading so the file doesn't have to be read again
+// to verify it.
+//
+// Files are written to a ".part" file (see partPath) first and interrupted
+// downloads are resumed from there. .gz and .xz files are decompressed on the fly, in which
+// case the sum is that of the decompressed file. Both formats carry their own
+// checksum of the decompressed data which is verified as the stream ends.
+//
+// If dst already exists and the server told us how to recognize it when it
+// was downloaded, it is only downloaded again if it changed on the server.
+//
+// When Segments is more than one, fresh uncompressed downloads are fetched as
+// that many concurrent byte ranges if the server supports them.
+//
+// size is the expected size of dst, or 0 if unknown, and is only used to
+// report progress. Cancelling ctx aborts the download, keeping what has been
+// downloaded so far to resume from.
+func Download(ctx context.Context, dlLink, dst string, size int64) (string, error) {
+	compressed := IsCompressed(dlLink)
+	part := partPath(dst)
+	h := sha256.New()
+
+	req, err := newRequest(ctx, "GET", dlLink)
+	if err != nil {
+		return "", err
+	}
+	conditional := setConditional(req, dlLink, dst)
+
+	// pick up where an interrupted download left off, which means hashing
+	// what we already have
+	var offset int64
+	if !compressed && !conditional {
+		if n, err := hashFile(part, h); err == nil {
+			offset = n
+		}
+	}
+
+	// split fresh downloads into segments when asked to
+	if Segments > 1 && !compressed && !conditional && offset == 0 {
+		sum, header, err := downloadSegmented(ctx, dlLink, part, Segments)
+		if err != errNoRanges {
+			if err != nil {
+				return "", err
+			}
+			if err = os.Rename(part, dst); err != nil {
+				return "", err
+			}
+			if err := saveValidators(dst, dlLink, &http.Response{Header: header}); err != nil {
+				fmt.Printf("  failed to save cache validators: %v\n", err)
+			}
+			fmt.Printf("Download saved to ./%v \n", dst)
+			return sum, nil
+		}
+	}
+
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
 
 	// start download
-	fmt.Printf("Downloading %v...\n", req.URL())
-	resp := client.Do(req)
-	fmt.Printf("  %v\n", resp.HTTPResponse.Status)
+	fmt.Printf("Downloading %v...\n", dlLink)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	fmt.Printf("  %v\n", resp.Status)
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("%s", resp.Status)
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		fmt.Printf("Unchanged since the last download, using ./%v\n", dst)
+		if _, err := hashFile(dst, h); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 && resp.StatusCode == http.StatusPartialContent {
+		fmt.Printf("  resuming from %v bytes\n", offset)
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	} else {
+		// the server sent everything, start over
+		offset = 0
+		h.Reset()
+	}
+
+	body := &countingReader{r: resp.Body}
+	var src io.Reader = body
+	if strings.HasSuffix(dlLink, ".xz") {
+		src, err = xz.NewReader(body)
+	} else if strings.HasSuffix(dlLink, ".gz") {
+		src, err = gzip.NewReader(body)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.OpenFile(part, flags, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	written := &countingReader{r: io.TeeReader(src, h)}
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(f, written)
+		done <- err
+	}()
 
 	// start UI loop
 	t := time.NewTicker(500 * time.Millisecond)
 	defer t.Stop()
 
+	// a known final size is the better total since compressed downloads
+	// transfer less than they write
+	var p Progress
+	total, transferred := offset+resp.ContentLength, body.count
+	if resp.ContentLength < 0 {
+		// chunked responses don't say, but asking for a range often does
+		if n, err := AssetSize(ctx, dlLink); err == nil && n > 0 {
+			total = n
+		}
+	}
+	if size > 0 {
+		total, transferred = size, written.count
+	}
 Loop:
 	for {
 		select {
 		case <-t.C:
-			fmt.Printf("  transferred %v / %v bytes (%.2f%%)\n",
-				resp.BytesComplete(),
-				resp.Size,
-				100*resp.Progress())
+			p.Print(offset+transferred(), total)
 
-		case <-resp.Done:
+		case err = <-done:
 			// download is complete
+			p.Done()
 			break Loop
 		}
 	}
 
-	// check for errors
-	if err := resp.Err(); err != nil {
-		fmt.Fprintf(os.Stderr, "Download failed: %v\n", err)
-		os.Exit(1)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		// compressed downloads can't be resumed so don't keep them around
+		if compressed {
+			os.Remove(part)
+		}
+		return "", err
+	}
+	if err = os.Rename(part, dst); err != nil {
+		return "", err
 	}
+	if err := saveValidators(dst, dlLink, resp); err != nil {
+		fmt.Printf("  failed to save cache validators: %v\n", err)
+	}
+
+	fmt.Printf("Download saved to ./%v \n", dst)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
 
-	fmt.Printf("Download saved to ./%v \n", resp.Filename)
+// newRequest builds a request for dlLink with the headers every server we
+// download from expects.
+func newRequest(ctx context.Context, method, dlLink string) (*http.Request, error) {
+	req, err := http.NewRequest(method, dlLink, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", UserAgent)
+	// Referrer needs to be set for TWRP
+	req.Header.Set("Referer", dlLink)
+	for name, values := range Headers {
+		req.Header[name] = values
+	}
+	return req, nil
+}
+
+// VerifyFile checks a file that wasn't downloaded this run, such as one left
+// over from an earlier run, against its hex encoded SHA-256 sum.
+func VerifyFile(file, sum string) error {
+	h := sha256.New()
+	if _, err := hashFile(file, h); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != sum {
+		return fmt.Errorf("%s is corrupt (sha256 %s, expected %s)", file, actual, sum)
+	}
+	return nil
+}
+
+// hashFile writes the contents of file to h and returns its size.
+func hashFile(file string, h hash.Hash) (int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return io.Copy(h, f)
 }
@@ -0,0 +1,33 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// PreferFamily makes downloads connect over "tcp4" (IPv4) or "tcp6" (IPv6)
+// first, falling back to the other family only when that fails. The default
+// dialer already races both families, but on dual-stack networks with a
+// broken IPv6 route a connection can succeed and then stall, which racing
+// doesn't catch. Each new connection prints the family it ended up using.
+func PreferFamily(network string) {
+	transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil && ctx.Err() == nil {
+			conn, err = dialer.DialContext(ctx, "tcp", addr)
+		}
+		if err == nil {
+			fmt.Printf("  connected to %s over %s\n", addr, family(conn.RemoteAddr()))
+		}
+		return conn, err
+	}
+}
+
+// family names the IP version of addr.
+func family(addr net.Addr) string {
+	if tcp, ok := addr.(*net.TCPAddr); ok && tcp.IP.To4() == nil {
+		return "IPv6"
+	}
+	return "IPv4"
+}
@@ -0,0 +1,69 @@
+package remote
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// Headers are sent with every request, on top of the defaults, for servers
+// that need something like an API key to serve files.
+var Headers = http.Header{}
+
+// AddHeader adds a header given as "Name: Value" to Headers.
+func AddHeader(header string) error {
+	i := strings.Index(header, ":")
+	if i <= 0 {
+		return fmt.Errorf("header %q is not in the form \"Name: Value\"", header)
+	}
+	name, value := strings.TrimSpace(header[:i]), strings.TrimSpace(header[i+1:])
+	if !validHeaderName(name) {
+		return fmt.Errorf("header name %q contains invalid characters", name)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("header %s has a value spanning several lines", name)
+	}
+	Headers.Add(name, value)
+	return nil
+}
+
+// validHeaderName reports whether name is an HTTP token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r > 0x7e || r <= ' ' || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// sensitiveHeaderWords mark headers whose values shouldn't be shown.
+var sensitiveHeaderWords = []string{"auth", "key", "token", "secret", "cookie", "password"}
+
+// RedactedHeaders returns Headers as sorted "Name: Value" lines for showing
+// the user, with the values of headers that look like credentials hidden.
+func RedactedHeaders() []string {
+	var lines []string
+	for name, values := range Headers {
+		redact := false
+		lower := strings.ToLower(name)
+		for _, word := range sensitiveHeaderWords {
+			if strings.Contains(lower, word) {
+				redact = true
+			}
+		}
+		for _, v := range values {
+			if redact {
+				v = "<redacted>"
+			}
+			lines = append(lines, textproto.CanonicalMIMEHeaderKey(name)+": "+v)
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
@@ -0,0 +1,101 @@
+package remote
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// Manifest maps file names to their hex encoded SHA-256 sums as listed in a
+// SHA256SUMS file.
+type Manifest map[string]string
+
+// ParseManifest parses the output of sha256sum, where each line is
+// "[sum]  [file]" or "[sum] *[file]" for files hashed in binary mode.
+func ParseManifest(r io.Reader) (Manifest, error) {
+	m := make(Manifest)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 || len(fields[0]) != 64 {
+			return nil, fmt.Errorf("malformed manifest line: %q", line)
+		}
+		file := strings.TrimPrefix(strings.TrimSpace(fields[1]), "*")
+		m[file] = strings.ToLower(fields[0])
+	}
+	return m, scanner.Err()
+}
+
+// FetchManifest downloads the SHA256SUMS file at manifestURL, checks it
+// against the detached signature published alongside it at
+// manifestURL + ".gpg" and parses it.
+func FetchManifest(ctx context.Context, manifestURL string, keyring openpgp.KeyRing) (Manifest, error) {
+	manifest, err := fetch(ctx, manifestURL)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := fetch(ctx, manifestURL+".gpg")
+	if err != nil {
+		return nil, err
+	}
+	return CheckManifest(manifest, signature, keyring)
+}
+
+// ReadManifest is FetchManifest for a manifest saved at path, with its
+// signature at path + ".gpg".
+func ReadManifest(path string, keyring openpgp.KeyRing) (Manifest, error) {
+	manifest, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := ioutil.ReadFile(path + ".gpg")
+	if err != nil {
+		return nil, err
+	}
+	return CheckManifest(manifest, signature, keyring)
+}
+
+// CheckManifest checks manifest against its detached signature and parses it.
+func CheckManifest(manifest, signature []byte, keyring openpgp.KeyRing) (Manifest, error) {
+	_, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(manifest), bytes.NewReader(signature), nil)
+	if err != nil {
+		return nil, fmt.Errorf("bad manifest signature: %v", err)
+	}
+	return ParseManifest(bytes.NewReader(manifest))
+}
+
+// fetchTimeout caps how long fetch waits on a stalled server.
+const fetchTimeout = time.Minute
+
+// fetch downloads the small file at dlLink into memory.
+func fetch(ctx context.Context, dlLink string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	req, err := newRequest(ctx, "GET", dlLink)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("%s: %s", dlLink, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
@@ -0,0 +1,46 @@
+package remote
+
+import (
+	"fmt"
+)
+
+// InPlaceProgress redraws transfer progress on a single line, which only
+// makes sense on a terminal. When it is off, a new line is printed every
+// progressStep percent instead so redirected output stays readable.
+var InPlaceProgress = true
+
+const progressStep = 10
+
+// Progress prints the progress of a single transfer, such as a download or
+// a push to the device.
+type Progress struct {
+	lastStep int
+}
+
+// Print reports that transferred of total bytes are done. total is 0 or less
+// if unknown.
+func (p *Progress) Print(transferred, total int64) {
+	if total <= 0 {
+		if InPlaceProgress {
+			fmt.Printf("\r  transferred %v bytes", transferred)
+		}
+		return
+	}
+
+	percent := 100 * float64(transferred) / float64(total)
+	if InPlaceProgress {
+		fmt.Printf("\r  transferred %v / %v bytes (%.2f%%)", transferred, total, percent)
+		return
+	}
+	if step := int(percent) / progressStep * progressStep; step > p.lastStep {
+		fmt.Printf("  %d%%\n", step)
+		p.lastStep = step
+	}
+}
+
+// Done ends the progress line once the transfer has finished.
+func (p *Progress) Done() {
+	if InPlaceProgress {
+		fmt.Println()
+	}
+}
@@ -0,0 +1,161 @@
+package remote
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Segments is how many byte ranges Download fetches at once. Servers that
+// don't support ranges are always downloaded from in one go.
+var Segments = 1
+
+// errNoRanges means a segmented download isn't possible and Download should
+// fall back to a single stream.
+var errNoRanges = errors.New("server doesn't support byte ranges")
+
+// offsetWriter writes sequentially to f starting at off.
+type offsetWriter struct {
+	f   *os.File
+	off int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
+
+// downloadSegmented fetches dlLink into part as n concurrent byte ranges and
+// returns the hex encoded SHA-256 sum of the result, along with the headers
+// of the first segment for saving its validators. Segments arrive out of
+// order so the file is hashed once complete, and a failed download can't be
+// resumed.
+func downloadSegmented(ctx context.Context, dlLink, part string, n int) (string, http.Header, error) {
+	resp, err := head(ctx, dlLink)
+	if err != nil {
+		return "", nil, errNoRanges
+	}
+	size := responseSize(resp)
+	if size < int64(n) || (resp.Header.Get("Accept-Ranges") != "bytes" && resp.StatusCode != http.StatusPartialContent) {
+		return "", nil, errNoRanges
+	}
+
+	f, err := os.Create(part)
+	if err != nil {
+		return "", nil, err
+	}
+	if err = f.Truncate(size); err != nil {
+		f.Close()
+		return "", nil, err
+	}
+
+	fmt.Printf("Downloading %v in %d segments...\n", dlLink, n)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var header http.Header
+	counters := make([]*countingReader, n)
+	errs := make(chan error, n+1)
+	var wg sync.WaitGroup
+	segment := size / int64(n)
+	for i := 0; i < n; i++ {
+		start, end := int64(i)*segment, int64(i+1)*segment-1
+		if i == n-1 {
+			end = size - 1
+		}
+		counters[i] = &countingReader{}
+		wg.Add(1)
+		var first *http.Header
+		if i == 0 {
+			first = &header
+		}
+		go func(c *countingReader, start, end int64, first *http.Header) {
+			defer wg.Done()
+			if err := fetchSegment(ctx, dlLink, f, start, end, c, first); err != nil {
+				errs <- err
+				cancel()
+			}
+		}(counters[i], start, end, first)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// start UI loop
+	t := time.NewTicker(500 * time.Millisecond)
+	defer t.Stop()
+
+	var p Progress
+Loop:
+	for {
+		select {
+		case <-t.C:
+			var transferred int64
+			for _, c := range counters {
+				transferred += c.count()
+			}
+			p.Print(transferred, size)
+
+		case <-done:
+			p.Done()
+			break Loop
+		}
+	}
+
+	if cerr := f.Close(); cerr != nil {
+		errs <- cerr
+	}
+	close(errs)
+	if err := <-errs; err != nil {
+		os.Remove(part)
+		return "", nil, err
+	}
+
+	h := sha256.New()
+	if _, err := hashFile(part, h); err != nil {
+		return "", nil, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), header, nil
+}
+
+// fetchSegment downloads bytes start to end of dlLink into the same place in
+// f, counting them through c. The response headers are stored in header
+// unless it is nil.
+func fetchSegment(ctx context.Context, dlLink string, f *os.File, start, end int64, c *countingReader, header *http.Header) error {
+	req, err := newRequest(ctx, "GET", dlLink)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(end, 10))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("unexpected response to range request: %s", resp.Status)
+	}
+	if header != nil {
+		*header = resp.Header
+	}
+
+	c.r = resp.Body
+	n, err := io.Copy(&offsetWriter{f, start}, c)
+	if err == nil && n != end-start+1 {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
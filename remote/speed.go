@@ -0,0 +1,48 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"time"
+)
+
+// speedTestBytes is how much of a file MeasureSpeed downloads.
+const speedTestBytes = 2 << 20
+
+// speedTestTimeout caps how long MeasureSpeed takes on a slow server.
+const speedTestTimeout = 10 * time.Second
+
+// MeasureSpeed downloads the start of dlLink and returns how fast it came in,
+// in bytes per second, for picking the fastest of several mirrors.
+func MeasureSpeed(ctx context.Context, dlLink string) (float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, speedTestTimeout)
+	defer cancel()
+
+	req, err := newRequest(ctx, "GET", dlLink)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", "bytes=0-"+strconv.Itoa(speedTestBytes-1))
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return 0, fmt.Errorf("%s", resp.Status)
+	}
+
+	// servers ignoring the range send everything, so stop reading in time
+	n, err := io.Copy(ioutil.Discard, io.LimitReader(resp.Body, speedTestBytes))
+	elapsed := time.Since(start).Seconds()
+	if n == 0 {
+		return 0, err
+	}
+	// running out of time still leaves a measurement
+	return float64(n) / elapsed, nil
+}
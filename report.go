@@ -0,0 +1,84 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"./android"
+	"./installer"
+)
+
+// reportDevice is the config being installed, once the device is identified,
+// for diagnostic reports.
+var reportDevice *installer.Device
+
+// writeReport bundles what is needed to look into the failure err into a zip
+// next to the executable, for attaching to bug reports. It returns the path
+// of the zip.
+func writeReport(err error) (string, error) {
+	dir := "."
+	if exe, err := os.Executable(); err == nil {
+		dir = filepath.Dir(exe)
+	}
+	name := filepath.Join(dir, "installer-report-"+time.Now().Format("20060102-150405")+".zip")
+
+	f, err := os.Create(name)
+	if err != nil {
+		return "", err
+	}
+	z := zip.NewWriter(f)
+
+	adb, fastboot := android.NewAdbClient(), android.NewFastbootClient()
+	adbVersion, _ := adb.Version()
+	fastbootVersion, _ := fastboot.Version()
+	files := []struct{ name, content string }{
+		{"report.txt", fmt.Sprintf("Nethunter installer version %s\nOS: %s/%s\nadb: %s\nfastboot: %s\n\nError: %v\n",
+			Version, runtime.GOOS, runtime.GOARCH, adbVersion, fastbootVersion, err)},
+		{"commands.log", strings.Join(android.RecentCommands(), "\n\n")},
+	}
+	if reportDevice != nil {
+		b, err := json.MarshalIndent(reportDevice, "", "  ")
+		if err == nil {
+			files = append(files, struct{ name, content string }{"device.json", string(b)})
+		}
+	}
+
+	for _, file := range files {
+		w, err := z.Create(file.name)
+		if err == nil {
+			_, err = w.Write([]byte(file.content))
+		}
+		if err != nil {
+			z.Close()
+			f.Close()
+			return "", err
+		}
+	}
+	if err := z.Close(); err != nil {
+		f.Close()
+		return "", err
+	}
+	return name, f.Close()
+}
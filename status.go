@@ -0,0 +1,74 @@
+//
+// Copyright 2017 The Maru OS Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"./installer"
+)
+
+// installStatus is a snapshot of how far along the install is.
+type installStatus struct {
+	Step      string  `json:"step"`
+	Percent   float64 `json:"percent"`
+	LastError string  `json:"last_error,omitempty"`
+}
+
+var (
+	statusMu sync.Mutex
+	status   = installStatus{Step: installer.StepStart}
+)
+
+// setStep records that the install has moved on to step.
+func setStep(step string, percent float64) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	status.Step = step
+	status.Percent = percent
+}
+
+// setLastError records the last error reported to the user.
+func setLastError(msg string) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	status.LastError = msg
+}
+
+func getStatus() installStatus {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	return status
+}
+
+// serveStatus serves the install status as JSON on addr in the background so
+// long running installs can be followed remotely.
+func serveStatus(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(getStatus())
+	})
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			iEcho("Warning: failed to serve install status: " + err.Error())
+		}
+	}()
+}
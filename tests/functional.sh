@@ -21,7 +21,7 @@ set -u
 readonly SCRIPT_DIR="$(dirname "$0")"
 source "${SCRIPT_DIR}/test.sh"
 
-# keep in sync with exit codes in main.go
+# keep in sync with exit codes in installer/errors.go
 readonly SUCCESS=0
 readonly SUCCESS_BASE=$(( 1 << 5 ))
 readonly SUCCESS_USER_ABORT=$(( SUCCESS_BASE + 1 ))
@@ -34,6 +34,10 @@ readonly ERROR_ADB=$(( ERROR_BASE + 4 ))
 readonly ERROR_FASTBOOT=$(( ERROR_BASE + 5 ))
 readonly ERROR_REMOTE=$(( ERROR_BASE + 6 ))
 readonly ERROR_TWRP=$(( ERROR_BASE + 7 ))
+readonly ERROR_TIMEOUT=$(( ERROR_BASE + 8 ))
+readonly ERROR_CRASH=$(( ERROR_BASE + 9 ))
+readonly ERROR_NO_DEVICE_CONFIG=$(( ERROR_BASE + 10 ))
+readonly ERROR_VERIFY=$(( ERROR_BASE + 11 ))
 
 mock_fastboot () {
     local readonly in_bootloader="$1"
@@ -142,6 +146,15 @@ case "\$*" in
     "reboot bootloader")
         exit 0
         ;;
+    "shell command -v twrp")
+        echo "/sbin/twrp"
+        exit 0
+        ;;
+    "shell cat '/tmp/nh-"*)
+        # detached jobs in TWRP finish successfully
+        echo "0"
+        exit 0
+        ;;
 esac
 
 case "\$1" in
@@ -206,12 +219,12 @@ tassert_eq $SUCCESS_BOOTLOADER_UNLOCKED $?
 techo "abort if using an unsupported device"
 mock_fastboot "true" "somefakedevice" "unlocked"
 echo "yes" | ./install >/dev/null
-tassert_eq $ERROR_REMOTE $?
+tassert_eq $ERROR_NO_DEVICE_CONFIG $?
 
 techo "abort if using an unsupported device with similar name"
 mock_fastboot "true" "hammer" "unlocked"
 echo "yes" | ./install >/dev/null
-tassert_eq $ERROR_REMOTE $?
+tassert_eq $ERROR_NO_DEVICE_CONFIG $?
 
 techo "install succesfully on unlocked flo with workaround"
 mock_fastboot "true" "flo" "unlocked"
@@ -226,7 +239,7 @@ tassert_eq $SUCCESS $?
 # misc tests
 
 techo "use a valid URL for wgetting 51-android.rules"
-grep wget <strings.go | tr -d '$' | cut -f 1 -d '|' | bash &>/dev/null
+grep wget <installer/strings.go | tr -d '$' | cut -f 1 -d '|' | bash &>/dev/null
 tassert_eq $SUCCESS $?
 
 texit
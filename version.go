@@ -18,3 +18,7 @@ package main
 
 // Filled in at build time.
 var Version = "undefined"
+
+// ConfigSum is the SHA-256 sum of the devices.toml shipped with this build,
+// filled in at build time. Empty skips the check.
+var ConfigSum = ""